@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// resolveCache remembers the DIDs of handles, the handles of DIDs, and the PDSes that host
+// their repos between runs, so that repeat runs mostly skip resolving the same users over the network.
+// It lives in the user's cache directory, such as ~/.cache/gomoderate/resolve.json.
+// Each key starts with the --pds and --plc servers that told us the entry (see cacheServers),
+// so that one server's answers are never used for another.
+//
+// It is only a cache: if it can't be read or written, we warn and carry on without it.
+type resolveCache struct {
+	mu      sync.Mutex
+	path    string                // empty if we could not find the cache directory
+	dirty   bool                  // whether we have entries to save
+	Dids    map[string]cacheEntry `json:"dids"`    // the handle for each DID
+	Handles map[string]cacheEntry `json:"handles"` // the DID for each handle, lowercased
+	Pdses   map[string]cacheEntry `json:"pdses"`   // the PDS URL for each DID
+}
+
+type cacheEntry struct {
+	Value       string    `json:"value"`
+	DisplayName string    `json:"displayName,omitempty"`
+	Fetched     time.Time `json:"fetched"`
+}
+
+// cacheTTL is set by our --cache-ttl flag. Zero or less disables the cache.
+var cacheTTL = 24 * time.Hour
+
+var (
+	cacheOnce sync.Once
+	cache     *resolveCache
+)
+
+// resolutions returns our resolve cache, reading it from disk the first time.
+func resolutions() *resolveCache {
+	cacheOnce.Do(func() {
+		cache = newResolveCache()
+		if cacheTTL <= 0 {
+			return
+		}
+		path, err := cachePath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: not caching resolved users: %v\n", err)
+			return
+		}
+		cache.path = path
+		b, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		if err == nil {
+			err = json.Unmarshal(b, cache)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: ignoring resolve cache %s: %v\n", path, err)
+			cache = newResolveCache()
+			cache.path = path
+		}
+	})
+	return cache
+}
+
+func newResolveCache() *resolveCache {
+	return &resolveCache{
+		Dids:    make(map[string]cacheEntry),
+		Handles: make(map[string]cacheEntry),
+		Pdses:   make(map[string]cacheEntry),
+	}
+}
+
+// cachePath returns where our resolve cache lives.
+func cachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gomoderate", "resolve.json"), nil
+}
+
+// byDid returns the cached user for did, if we have a fresh entry.
+func (rc *resolveCache) byDid(did string) (resolvedUser, bool) {
+	e, ok := rc.get(rc.Dids, did)
+	return resolvedUser{handle: e.Value, did: did, displayName: e.DisplayName}, ok
+}
+
+// byHandle returns the cached user for handle, if we have a fresh entry.
+func (rc *resolveCache) byHandle(handle string) (resolvedUser, bool) {
+	e, ok := rc.get(rc.Handles, strings.ToLower(handle))
+	return resolvedUser{handle: handle, did: e.Value, displayName: e.DisplayName}, ok
+}
+
+// pds returns the cached PDS URL for did, if we have a fresh entry.
+func (rc *resolveCache) pds(did string) (string, bool) {
+	e, ok := rc.get(rc.Pdses, did)
+	return e.Value, ok
+}
+
+// cacheServers returns the prefix for our cache keys, which names the servers we use.
+func cacheServers() string {
+	return pdsServer + " " + plcServer + " "
+}
+
+func (rc *resolveCache) get(m map[string]cacheEntry, key string) (cacheEntry, bool) {
+	if cacheTTL <= 0 {
+		return cacheEntry{}, false
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e, ok := m[cacheServers()+key]
+	if !ok || time.Since(e.Fetched) > cacheTTL {
+		return cacheEntry{}, false
+	}
+	return e, true
+}
+
+// remember records u's handle for its DID, and its DID for its handle.
+func (rc *resolveCache) remember(u resolvedUser) {
+	rc.set(rc.Handles, strings.ToLower(u.handle), cacheEntry{Value: u.did, DisplayName: u.displayName})
+	rc.rememberHandle(u)
+}
+
+// rememberHandle records u's handle for its DID, but not the reverse.
+// We use it when the handle comes from the DID document alone, which the handle hasn't confirmed.
+func (rc *resolveCache) rememberHandle(u resolvedUser) {
+	rc.set(rc.Dids, u.did, cacheEntry{Value: u.handle, DisplayName: u.displayName})
+}
+
+// rememberPds records the URL of the PDS that hosts did's repo.
+func (rc *resolveCache) rememberPds(did, pds string) {
+	rc.set(rc.Pdses, did, cacheEntry{Value: pds})
+}
+
+func (rc *resolveCache) set(m map[string]cacheEntry, key string, e cacheEntry) {
+	if cacheTTL <= 0 {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e.Fetched = time.Now().UTC()
+	m[cacheServers()+key] = e
+	rc.dirty = true
+}
+
+// save writes any new entries to disk, dropping expired ones as it goes.
+func (rc *resolveCache) save() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if !rc.dirty || rc.path == "" {
+		return
+	}
+	for _, m := range []map[string]cacheEntry{rc.Dids, rc.Handles, rc.Pdses} {
+		for k, e := range m {
+			if time.Since(e.Fetched) > cacheTTL {
+				delete(m, k)
+			}
+		}
+	}
+	err := rc.write()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: saving resolve cache: %v\n", err)
+		return
+	}
+	rc.dirty = false
+}
+
+// write replaces the cache file, via a temporary file so that
+// a concurrent run never sees it half written.
+func (rc *resolveCache) write() error {
+	b, err := json.Marshal(rc)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(rc.path), 0o700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(rc.path), "resolve-*.json")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), rc.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// doCacheClearCmd removes our resolve cache.
+func doCacheClearCmd(c *cli.Context) error {
+	path, err := cachePath()
+	if err != nil {
+		return fmt.Errorf("clear cache: %w", err)
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println("cache is already empty")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("clear cache: %w", err)
+	}
+	fmt.Printf("removed %s\n", path)
+	return nil
+}
@@ -5,23 +5,29 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
 
-	"github.com/bluesky-social/indigo/api"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/api/bsky"
 	cliutil "github.com/bluesky-social/indigo/cmd/gosky/util"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/xrpc"
 	"github.com/ipfs/go-cid"
-	"github.com/polydawn/refmt/cbor"
-	rejson "github.com/polydawn/refmt/json"
-	"github.com/polydawn/refmt/shared"
 	"github.com/thepudds/bluesky-aux/appkey"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/exp/slices"
+	"golang.org/x/time/rate"
 )
 
 // newXrpcClient returns an unauthenticated client
@@ -65,8 +71,18 @@ func authenticate(xrpcc *xrpc.Client) error {
 }
 
 type resolvedUser struct {
-	handle string // should not include leading @. consumers add if needed.
-	did    string // should be prefixed with "did"
+	handle      string // should not include leading @. consumers add if needed.
+	did         string // should be prefixed with "did"
+	displayName string // if known
+	status      string // if set, what is wrong with the account, such as "tombstoned"
+}
+
+// knownHandle returns u's handle, or "" if we only have the placeholder invalidHandle.
+func (u resolvedUser) knownHandle() string {
+	if u.handle == invalidHandle {
+		return ""
+	}
+	return u.handle
 }
 
 func doListMutesCmd(c *cli.Context, xrpcc *xrpc.Client) error {
@@ -81,55 +97,747 @@ func doListMutesCmd(c *cli.Context, xrpcc *xrpc.Client) error {
 	return nil
 }
 
-func doMuteCmd(c *cli.Context, xrpcc *xrpc.Client, handles []string) error {
-	fmt.Println("muting...")
-	resolvedUsers, err := resolveHandles(xrpcc, trimAts(handles))
+func doUsersCmd(c *cli.Context, xrpcc *xrpc.Client, st *store, m moderation, handles []string) error {
+	fmt.Printf("%s...\n", m.gerund)
+	resolvedUsers, err := resolveHandles(xrpcc, trimAts(handles), true)
 	if err != nil {
-		return fmt.Errorf("muting: %w", err)
+		return fmt.Errorf("%s: %w", m.gerund, err)
+	}
+	var targets targetList
+	for _, u := range resolvedUsers {
+		targets.add(u, commandLineSource)
 	}
-	err = muteUsers(xrpcc, didsFromUsers(resolvedUsers))
+	err = moderate(c, xrpcc, st, m, targets, false)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func doMuteFromUserBlocksCmd(c *cli.Context, xrpcc *xrpc.Client, handles []string) error {
-	ctx := context.TODO()
+func doFromUserBlocksCmd(c *cli.Context, xrpcc *xrpc.Client, st *store, m moderation, handles []string) error {
 	fmt.Println("getting blocks set by the supplied users...")
-	resolvedUsers, err := resolveHandles(xrpcc, trimAts(handles))
+	var targets targetList
+	err := addFromUserBlocks(xrpcc, &targets, handles)
+	if err != nil {
+		return fmt.Errorf("%s from user blocks: %w", m.gerund, err)
+	}
+	if len(targets.targets) == 0 && !c.Bool("sync") {
+		fmt.Println("no blocks found")
+		return nil
+	}
+
+	// Keep only users blocked by at least min of the trusted users, the larger of
+	// --min-sources and --min-fraction of them. Without those flags, as for unmute
+	// and unblock, everyone is kept.
+	trusted := len(dedup(sourceNames(targets.fetched)))
+	min := c.Int("min-sources")
+	// Allow for floating-point error, so that 0.28 of 25 users is 7, not 8.
+	if n := int(math.Ceil(c.Float64("min-fraction")*float64(trusted) - 1e-9)); n > min {
+		min = n
+	}
+	allowed, err := readAllowlist(c.String("allowlist"))
+	if err != nil {
+		return fmt.Errorf("%s from user blocks: %w", m.gerund, err)
+	}
+	follows, err := myFollows(c, xrpcc, m)
 	if err != nil {
-		return fmt.Errorf("muting from user blocks: %w", err)
+		return fmt.Errorf("%s from user blocks: %w", m.gerund, err)
 	}
+	quorum := spareFollows(m, spareAllowlisted(m, targets, allowed), follows)
+	quorum, below := quorum.scored(float64(min), func(source) float64 { return 1 })
+	if len(below) > 0 {
+		fmt.Printf("skipping %d users blocked by fewer than %d of %d supplied users\n", len(below), min, trusted)
+		if dryRun() {
+			printTargets(below)
+		}
+	}
+
+	if len(quorum.targets) > 0 {
+		err = moderate(c, xrpcc, st, m, quorum, true)
+		if err != nil {
+			return err
+		}
+	}
+	// spared users and users below the quorum are still listed by their sources.
+	return syncDropped(c, xrpcc, st, m, targets)
+}
 
-	blockedUsers, err := listBlocks(ctx, xrpcc, resolvedUsers)
+func doFromFileCmd(c *cli.Context, xrpcc *xrpc.Client, st *store, m moderation, filenames []string) error {
+	var targets targetList
+	err := addFromFiles(&targets, filenames)
+	if err != nil {
+		return fmt.Errorf("%s from file: %w", m.gerund, err)
+	}
+	allowed, err := readAllowlist(c.String("allowlist"))
+	if err != nil {
+		return fmt.Errorf("%s from file: %w", m.gerund, err)
+	}
+	follows, err := myFollows(c, xrpcc, m)
+	if err != nil {
+		return fmt.Errorf("%s from file: %w", m.gerund, err)
+	}
+	err = moderateListed(c, xrpcc, st, m, targets, spareFollows(m, spareAllowlisted(m, targets, allowed), follows))
 	if err != nil {
 		return err
 	}
-	if len(blockedUsers) == 0 {
-		fmt.Println("no blocks found")
+	// spared users are still listed by their sources.
+	return syncDropped(c, xrpcc, st, m, targets)
+}
+
+func doFromURLCmd(c *cli.Context, xrpcc *xrpc.Client, st *store, m moderation, urls []string) error {
+	var targets targetList
+	err := addFromURLs(cliutil.NewHttpClient(), &targets, urls)
+	if err != nil {
+		return fmt.Errorf("%s from url: %w", m.gerund, err)
+	}
+	allowed, err := readAllowlist(c.String("allowlist"))
+	if err != nil {
+		return fmt.Errorf("%s from url: %w", m.gerund, err)
+	}
+	follows, err := myFollows(c, xrpcc, m)
+	if err != nil {
+		return fmt.Errorf("%s from url: %w", m.gerund, err)
+	}
+	err = moderateListed(c, xrpcc, st, m, targets, spareFollows(m, spareAllowlisted(m, targets, allowed), follows))
+	if err != nil {
+		return err
+	}
+	// spared users are still listed by their sources.
+	return syncDropped(c, xrpcc, st, m, targets)
+}
+
+// moderateListed mutes or blocks the kept users out of those listed by files or URLs,
+// saying so instead when there are none.
+func moderateListed(c *cli.Context, xrpcc *xrpc.Client, st *store, m moderation, listed, kept targetList) error {
+	switch {
+	case len(listed.targets) == 0:
+		fmt.Println("no users found")
+		return nil
+	case len(kept.targets) == 0:
+		fmt.Printf("no users left to %s\n", m.verb)
 		return nil
 	}
+	return moderate(c, xrpcc, st, m, kept, true)
+}
+
+// addFromUserBlocks adds the users blocked by any of the users with the supplied handles.
+func addFromUserBlocks(xrpcc *xrpc.Client, targets *targetList, handles []string) error {
+	resolvedUsers, err := resolveHandles(xrpcc, trimAts(handles), false)
+	if err != nil {
+		return err
+	}
+	blockedUsers, blockedBy, err := listBlocks(context.TODO(), xrpcc, resolvedUsers)
+	if err != nil {
+		return err
+	}
+	for _, u := range resolvedUsers {
+		targets.fetched = append(targets.fetched, source{kind: sourceUserBlocks, name: u.did, handle: u.knownHandle()})
+	}
+	for _, u := range blockedUsers {
+		for _, blocker := range blockedBy[u.did] {
+			targets.add(u, source{kind: sourceUserBlocks, name: blocker.did, handle: blocker.knownHandle()})
+		}
+	}
+	return nil
+}
+
+// addFromFiles adds the users listed in files.
+func addFromFiles(targets *targetList, filenames []string) error {
+	for _, filename := range filenames {
+		users, err := readUserListFile(filename)
+		if err != nil {
+			return err
+		}
+		// use the absolute path so that we can recognize this file later.
+		path, err := filepath.Abs(filename)
+		if err != nil {
+			return err
+		}
+		src := source{kind: sourceFile, name: path}
+		targets.fetched = append(targets.fetched, src)
+		for _, u := range users {
+			targets.add(u, src)
+		}
+	}
+	return nil
+}
+
+// addFromURLs adds the users listed at URLs.
+func addFromURLs(client *http.Client, targets *targetList, urls []string) error {
+	for _, url := range urls {
+		users, err := fetchUserList(client, url)
+		if err != nil {
+			return err
+		}
+		src := source{kind: sourceURL, name: url}
+		targets.fetched = append(targets.fetched, src)
+		for _, u := range users {
+			targets.add(u, src)
+		}
+	}
+	return nil
+}
 
-	err = muteUsers(xrpcc, didsFromUsers(blockedUsers))
+// doApplyCmd mutes and blocks users as declared by a config file.
+// Users already muted or blocked are left alone, as are allowlisted users.
+// We check our current mutes and blocks only once each, rather than once per source.
+func doApplyCmd(c *cli.Context, xrpcc *xrpc.Client, st *store, filename string) error {
+	cfg, err := readConfig(filename)
 	if err != nil {
 		return err
 	}
+
+	fmt.Printf("getting users from %d trusted sources...\n", len(cfg.Trusted))
+	client := cliutil.NewHttpClient()
+	desired := map[string]*targetList{muting.verb: {}, blocking.verb: {}}
+	weights := make(map[source]float64) // keyed by kind and name
+	for _, ts := range cfg.Trusted {
+		targets := desired[ts.action()]
+		n := len(targets.fetched)
+		switch {
+		case ts.User != "":
+			err = addFromUserBlocks(xrpcc, targets, []string{ts.User})
+		case ts.File != "":
+			err = addFromFiles(targets, []string{ts.File})
+		case ts.URL != "":
+			err = addFromURLs(client, targets, []string{ts.URL})
+		}
+		if err != nil {
+			return fmt.Errorf("apply %s: %w", filename, err)
+		}
+		for _, src := range targets.fetched[n:] {
+			weights[source{kind: src.kind, name: src.name}] = ts.weight()
+		}
+	}
+	weight := func(src source) float64 {
+		return weights[source{kind: src.kind, name: src.name}]
+	}
+
+	allowed, err := resolveAllowlist(xrpcc, cfg.Allow)
+	if err != nil {
+		return fmt.Errorf("apply %s: allowlist: %w", filename, err)
+	}
+	if cfg.Allowlist != "" {
+		listed, err := readAllowlist(cfg.Allowlist)
+		if err != nil {
+			return fmt.Errorf("apply %s: %w", filename, err)
+		}
+		for did := range listed {
+			allowed[did] = true
+		}
+	}
+
+	follows, err := myFollows(c, xrpcc, muting)
+	if err != nil {
+		return fmt.Errorf("apply %s: %w", filename, err)
+	}
+
+	for _, m := range []moderation{muting, blocking} {
+		if len(desired[m.verb].fetched) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s...\n", m.gerund)
+		targets := spareFollows(m, spareAllowlisted(m, *desired[m.verb], allowed), follows)
+		targets, below := targets.scored(cfg.threshold(), weight)
+		if len(below) > 0 {
+			fmt.Printf("skipping %d users with a score below %g\n", len(below), cfg.threshold())
+			if dryRun() {
+				printTargets(below)
+			}
+		}
+		if len(targets.targets) > 0 {
+			err := moderate(c, xrpcc, st, m, targets, true)
+			if err != nil {
+				return err
+			}
+		}
+		// allowlisted users are still listed by their sources.
+		err := syncDropped(c, xrpcc, st, m, *desired[m.verb])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDropped handles --sync, which undoes m for users that the fetched sources listed
+// when we last fetched them, but no longer list. We only undo mutes or blocks that gomoderate made,
+// and only when no other source still asks for them. Either way, we forget that the sources asked for them.
+func syncDropped(c *cli.Context, xrpcc *xrpc.Client, st *store, m moderation, listed targetList) error {
+	if !c.Bool("sync") {
+		return nil
+	}
+	account := xrpcc.Auth.Did
+
+	// Find the users each source no longer lists.
+	type drop struct {
+		u   sourcedUser
+		src source
+	}
+	var drops []drop
+	dropping := make(map[string]int) // how many sources no longer list a user, keyed by DID
+	for _, src := range listed.fetched {
+		users, err := st.sourcedUsers(account, src)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			if u.action != m.verb || listed.lists(u.user.did, src) {
+				continue
+			}
+			drops = append(drops, drop{u: u, src: src})
+			dropping[u.user.did]++
+		}
+	}
+
+	if len(drops) == 0 {
+		return nil
+	}
+
+	fmt.Printf("\nsyncing: %d users are no longer listed by %s\n", len(dropping), strings.Join(sourceNames(listed.fetched), ", "))
+	var undos, stillListed, notOurs targetList
+	for _, d := range drops {
+		// the sources left once the fetched sources no longer ask for the user.
+		remaining := d.u.others + 1 - dropping[d.u.user.did]
+		switch {
+		case remaining > 0:
+			stillListed.add(d.u.user, d.src)
+		case !d.u.ours:
+			notOurs.add(d.u.user, d.src)
+		default:
+			undos.add(d.u.user, source{kind: sourceDropped, name: d.src.name, handle: d.src.handle})
+		}
+	}
+	if len(stillListed.targets) > 0 {
+		fmt.Printf("keeping %d users that other sources still ask for:\n", len(stillListed.targets))
+		printUsers(stillListed.targets)
+	}
+	if len(notOurs.targets) > 0 {
+		fmt.Printf("keeping %d users that were not %s by gomoderate:\n", len(notOurs.targets), m.past)
+		printUsers(notOurs.targets)
+	}
+	if len(undos.targets) > 0 {
+		err := moderate(c, xrpcc, st, m.opposite(), undos, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if dryRun() {
+		return nil
+	}
+	for _, d := range drops {
+		err := st.dropReason(account, m.verb, d.u.user.did, d.src)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// sourceNames returns the names of sources for display.
+func sourceNames(sources []source) []string {
+	var names []string
+	for _, src := range sources {
+		names = append(names, src.String())
+	}
+	return names
+}
+
+// readAllowlist returns the DIDs listed in an allowlist file, which is a go-mod-user-list.
+// There is no allowlist if filename is empty.
+func readAllowlist(filename string) (map[string]bool, error) {
+	allowed := make(map[string]bool)
+	if filename == "" {
+		return allowed, nil
+	}
+	users, err := readUserListFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("allowlist: %w", err)
+	}
+	for _, u := range users {
+		allowed[u.did] = true
+	}
+	return allowed, nil
+}
+
+// spareAllowlisted returns the targets that are not allowlisted, and reports those that are.
+// Allowlisted users are never muted or blocked by a bulk command, even if every source lists them.
+func spareAllowlisted(m moderation, targets targetList, allowed map[string]bool) targetList {
+	kept, spared := targets.without(allowed)
+	if len(spared) > 0 {
+		fmt.Printf("not %s %d allowlisted users:\n", m.gerund, len(spared))
+		printTargets(spared)
+	}
+	return kept
+}
+
+// myFollows returns the DIDs of the users we follow, whom bulk commands do not mute or block.
+// It returns no one for --include-follows, or when m unmutes or unblocks.
+func myFollows(c *cli.Context, xrpcc *xrpc.Client, m moderation) (map[string]bool, error) {
+	follows := make(map[string]bool)
+	if m.undo || c.Bool("include-follows") {
+		return follows, nil
+	}
+	users, err := listFollows(xrpcc)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		follows[u.did] = true
+	}
+	return follows, nil
+}
+
+// spareFollows returns the targets we do not follow, and reports those we do.
+func spareFollows(m moderation, targets targetList, follows map[string]bool) targetList {
+	kept, spared := targets.without(follows)
+	if len(spared) > 0 {
+		fmt.Printf("not %s %d users you follow (use --include-follows to include them):\n", m.gerund, len(spared))
+		printTargets(spared)
+	}
+	return kept
+}
+
+// resolveAllowlist returns the DIDs of allowlisted users, which are handles or DIDs.
+func resolveAllowlist(xrpcc *xrpc.Client, users []string) (map[string]bool, error) {
+	allowed := make(map[string]bool)
+	var handles []string
+	for _, u := range users {
+		if isDid(u) {
+			if err := checkDid(u); err != nil {
+				return nil, err
+			}
+			allowed[u] = true
+		} else {
+			handles = append(handles, u)
+		}
+	}
+	resolvedUsers, err := resolveHandles(xrpcc, trimAts(handles), false)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range resolvedUsers {
+		allowed[u.did] = true
+	}
+	return allowed, nil
+}
+
+// doUndoCmd undoes the changes journaled for a run,
+// or if runID is 0, for our most recent run that has not already been undone.
+func doUndoCmd(c *cli.Context, xrpcc *xrpc.Client, st *store, runID int64) error {
+	account := xrpcc.Auth.Did
+	if runID == 0 {
+		var err error
+		runID, err = st.lastRun(account)
+		if err != nil {
+			return err
+		}
+		if runID == 0 {
+			fmt.Println("no runs found to undo")
+			return nil
+		}
+	}
+
+	r, actions, err := st.runActions(account, runID)
+	if err != nil {
+		return err
+	}
+	if r.undone != "" {
+		return fmt.Errorf("run %d was already undone at %s", runID, r.undone)
+	}
+	fmt.Printf("undoing run %d (%s at %s)...\n", runID, r.command, r.time)
+
+	// Undo in the reverse order, grouping by what undoes each action,
+	// such as unmuting the users that the run muted.
+	src := source{kind: sourceUndo, name: strconv.FormatInt(runID, 10)}
+	var undos []moderation
+	targets := make(map[string]*targetList)
+	for i := len(actions) - 1; i >= 0; i-- {
+		a := actions[i]
+		m, err := undoFor(a.action)
+		if err != nil {
+			return err
+		}
+		if targets[m.verb] == nil {
+			undos = append(undos, m)
+			targets[m.verb] = &targetList{}
+		}
+		targets[m.verb].add(a.user, src)
+	}
+
+	st.undoes = runID
+	for _, m := range undos {
+		err := moderate(c, xrpcc, st, m, *targets[m.verb], true)
+		if err != nil {
+			return err
+		}
+	}
+	if dryRun() {
+		return nil
+	}
+	return st.markUndone(runID)
+}
+
+func doListRunsCmd(c *cli.Context, xrpcc *xrpc.Client, st *store) error {
+	runs, err := st.runs(xrpcc.Auth.Did, 20)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		fmt.Println("no runs found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "run\ttime\tcommand\tchanges")
+	for _, r := range runs {
+		var notes []string
+		if r.undoes != 0 {
+			notes = append(notes, fmt.Sprintf("undo of run %d", r.undoes))
+		}
+		if r.undone != "" {
+			notes = append(notes, "undone at "+r.undone)
+		}
+		changes := r.counts
+		if len(notes) > 0 {
+			changes += " (" + strings.Join(notes, ", ") + ")"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", r.id, r.time, r.command, changes)
+	}
+	return w.Flush()
+}
+
+// doWhyCmd explains why our account mutes or blocks a user,
+// based on the sources and changes recorded in our state database.
+func doWhyCmd(c *cli.Context, xrpcc *xrpc.Client, st *store, user string) error {
+	u := resolvedUser{did: user}
+	if isDid(user) {
+		if err := checkDid(user); err != nil {
+			return fmt.Errorf("why: %w", err)
+		}
+	} else {
+		resolvedUsers, err := resolveHandles(xrpcc, trimAts([]string{user}), false)
+		if err != nil {
+			return fmt.Errorf("why: %w", err)
+		}
+		u = resolvedUsers[0]
+	}
+
+	account := xrpcc.Auth.Did
+	history, err := st.history(account, u.did)
+	if err != nil {
+		return err
+	}
+	reasons, err := st.reasonsFor(account, u.did)
+	if err != nil {
+		return err
+	}
+	// use the most recent handle we recorded, if needed.
+	for i := len(history) - 1; i >= 0 && u.handle == ""; i-- {
+		u.handle = history[i].handle
+	}
+	name := u.did
+	if u.handle != "" {
+		name = fmt.Sprintf("@%s (%s)", u.handle, u.did)
+	}
+
+	// Report where things stand now, which might differ from our records
+	// if the user was muted or blocked some other way, such as in the Bluesky app.
+	mutes, err := currentMutes(xrpcc)
+	if err != nil {
+		return fmt.Errorf("why: %w", err)
+	}
+	blocks, err := currentBlocks(xrpcc)
+	if err != nil {
+		return fmt.Errorf("why: %w", err)
+	}
+	_, muted := mutes[u.did]
+	_, blocked := blocks[u.did]
+	switch {
+	case muted && blocked:
+		fmt.Printf("%s is muted and blocked\n", name)
+	case muted:
+		fmt.Printf("%s is muted\n", name)
+	case blocked:
+		fmt.Printf("%s is blocked\n", name)
+	default:
+		fmt.Printf("%s is not muted or blocked\n", name)
+	}
+
+	if len(reasons) == 0 && len(history) == 0 {
+		fmt.Println("gomoderate has no record of muting or blocking this user")
+		return nil
+	}
+
+	for _, m := range []moderation{muting, blocking} {
+		first := true
+		for _, r := range reasons {
+			if r.action != m.verb {
+				continue
+			}
+			if first {
+				fmt.Printf("\n%s because of:\n", m.past)
+				first = false
+			}
+			fmt.Printf("   %s  (first seen %s, last seen %s)\n", r.src, r.firstSeen, r.lastSeen)
+		}
+	}
+
+	if len(history) > 0 {
+		fmt.Println("\nhistory:")
+	}
+	for _, e := range history {
+		what := e.action
+		if m, ok := moderationFor(e.action); ok {
+			what = m.past
+		}
+		by := e.command
+		if e.run != 0 {
+			by += fmt.Sprintf(" (run %d)", e.run)
+		}
+		var from []string
+		for _, src := range e.sources {
+			from = append(from, src.String())
+		}
+		fmt.Printf("   %s  %s by %s", e.time, what, by)
+		if len(from) > 0 {
+			fmt.Printf(", from %s", strings.Join(from, ", "))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// doUntrustCmd stops trusting a source, which is a user whose blocks we imported,
+// or a file or URL. Users that we muted or blocked only because of that source
+// are unmuted or unblocked. Users that another source also asks for are kept,
+// as are users that were muted or blocked some other way, such as in the Bluesky app.
+func doUntrustCmd(c *cli.Context, xrpcc *xrpc.Client, st *store, arg string) error {
+	var src source
+	switch {
+	case strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://"):
+		src = source{kind: sourceURL, name: arg}
+	case isDid(arg):
+		if err := checkDid(arg); err != nil {
+			return fmt.Errorf("untrust: %w", err)
+		}
+		src = source{kind: sourceUserBlocks, name: arg}
+	case !isFileArg(arg):
+		resolvedUsers, err := resolveHandles(xrpcc, trimAts([]string{arg}), true)
+		if err != nil {
+			return fmt.Errorf("untrust: %w (for a file that no longer exists, use a path such as ./%s)", err, arg)
+		}
+		src = source{kind: sourceUserBlocks, name: resolvedUsers[0].did, handle: resolvedUsers[0].handle}
+	default:
+		// the file need not still exist.
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			return fmt.Errorf("untrust: %w", err)
+		}
+		src = source{kind: sourceFile, name: path}
+	}
+
+	account := xrpcc.Auth.Did
+	users, err := st.sourcedUsers(account, src)
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		fmt.Printf("no users are muted or blocked because of %s\n", src)
+		return nil
+	}
+
+	untrust := source{kind: sourceUntrust, name: src.name, handle: src.handle}
+	undos := make(map[string]*targetList)
+	var kept, notOurs []*target
+	for _, u := range users {
+		switch {
+		case u.others > 0:
+			kept = append(kept, &target{resolvedUser: u.user})
+		case !u.ours:
+			notOurs = append(notOurs, &target{resolvedUser: u.user})
+		default:
+			if undos[u.action] == nil {
+				undos[u.action] = &targetList{}
+			}
+			undos[u.action].add(u.user, untrust)
+		}
+	}
+	fmt.Printf("%d users are muted or blocked because of %s\n", len(users), src)
+	if len(kept) > 0 {
+		fmt.Printf("\nkeeping %d users that other sources also ask for:\n", len(kept))
+		printUsers(kept)
+	}
+	if len(notOurs) > 0 {
+		fmt.Printf("\nkeeping %d users that were not muted or blocked by gomoderate:\n", len(notOurs))
+		printUsers(notOurs)
+	}
+
+	for _, m := range []moderation{unmuting, unblocking} {
+		targets := undos[m.opposite().verb]
+		if targets == nil {
+			continue
+		}
+		fmt.Printf("\n%s...\n", m.gerund)
+		err := moderate(c, xrpcc, st, m, *targets, true)
+		if err != nil {
+			return err
+		}
+	}
+	if dryRun() {
+		return nil
+	}
+	return st.forgetSource(account, src)
+}
+
+// isFileArg reports whether an untrust argument names a file rather than a user:
+// it exists on disk or is a path, like ./users.txt. A bare name, like user1.bsky.social,
+// that is not a file here is a handle.
+func isFileArg(arg string) bool {
+	if strings.HasPrefix(arg, "@") {
+		return false
+	}
+	if strings.ContainsAny(arg, "/"+string(filepath.Separator)) {
+		return true
+	}
+	_, err := os.Stat(arg)
+	return err == nil
+}
+
+// printUsers prints users without their sources.
+func printUsers(users []*target) {
+	for _, u := range users {
+		switch {
+		case u.handle == "":
+			fmt.Printf("   %s\n", u.did)
+		case u.status != "":
+			fmt.Printf("   %s @%s (%s)\n", u.did, u.handle, u.status)
+		default:
+			fmt.Printf("   %s @%s\n", u.did, u.handle)
+		}
+	}
+}
+
+// printUndoHint tells the user how to undo this run, if it changed anything.
+func printUndoHint(st *store) {
+	if st.run != 0 && st.undoes == 0 {
+		fmt.Printf("to undo, run: gomoderate undo %d\n", st.run)
+	}
+}
+
 // TODO: dids should be usernames, probably with @ and error if @ missing.
 func doListBlocksCmd(c *cli.Context, xrpcc *xrpc.Client, handles []string) error {
 	ctx := context.TODO()
 
 	printHeader(c, "users blocked", handles)
 
-	resolvedUsers, err := resolveHandles(xrpcc, trimAts(handles))
+	resolvedUsers, err := resolveHandles(xrpcc, trimAts(handles), false)
 	if err != nil {
 		return fmt.Errorf("list blocks: %w", err)
 	}
 
-	blockedUsers, err := listBlocks(ctx, xrpcc, resolvedUsers)
+	blockedUsers, _, err := listBlocks(ctx, xrpcc, resolvedUsers)
 	if err != nil {
 		return err
 	}
@@ -153,8 +861,7 @@ func listMutes(xrpcc *xrpc.Client) ([]resolvedUser, error) {
 		}
 
 		for _, f := range mutes.Mutes {
-			// TODO: consider flag to also include DisplayName
-			resolvedUsers = append(resolvedUsers, resolvedUser{handle: f.Handle, did: f.Did})
+			resolvedUsers = append(resolvedUsers, resolvedUser{handle: f.Handle, did: f.Did, displayName: stringOrEmpty(f.DisplayName)})
 		}
 		// fmt.Println("cursor:", cursor)
 		if mutes.Cursor == nil {
@@ -165,148 +872,516 @@ func listMutes(xrpcc *xrpc.Client) ([]resolvedUser, error) {
 	return resolvedUsers, nil
 }
 
-func resolveHandles(xrpcc *xrpc.Client, handles []string) ([]resolvedUser, error) {
+// listFollows returns the users our authenticated user follows.
+func listFollows(xrpcc *xrpc.Client) ([]resolvedUser, error) {
+	var resolvedUsers []resolvedUser
+	var cursor string
+	for {
+		follows, err := bsky.GraphGetFollows(context.TODO(), xrpcc, xrpcc.Auth.Did, cursor, 100)
+		if err != nil {
+			return nil, fmt.Errorf("list follows: %w", err)
+		}
+
+		for _, f := range follows.Follows {
+			resolvedUsers = append(resolvedUsers, resolvedUser{handle: f.Handle, did: f.Did})
+		}
+		if follows.Cursor == nil || len(follows.Follows) == 0 {
+			break
+		}
+		cursor = *follows.Cursor
+	}
+	return resolvedUsers, nil
+}
+
+// blockRecord is an app.bsky.graph.block record in a repo.
+type blockRecord struct {
+	subject   string // the blocked DID
+	rkey      string // the record key, which we need to delete the block
+	createdAt string // as recorded by the blocker's client, and not validated
+}
+
+// A recordWarning reports a record that we skipped because it was malformed.
+type recordWarning struct {
+	repo string // the DID of the repo
+	rkey string
+	err  error
+}
+
+func (w recordWarning) String() string {
+	return fmt.Sprintf("skipping malformed block record %s in the repo for %s: %v", w.rkey, w.repo, w.err)
+}
+
+// printWarnings reports skipped records on stderr, so that they do not mix with our results.
+func printWarnings(warnings []recordWarning) {
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+}
+
+// newBlockRecord validates a decoded block record.
+func newBlockRecord(rkey string, b *graphBlock) (blockRecord, error) {
+	if b.LexiconTypeID != "" && b.LexiconTypeID != blockCollection {
+		return blockRecord{}, fmt.Errorf("unexpected record type %q", b.LexiconTypeID)
+	}
+	if !isDid(b.Subject) {
+		return blockRecord{}, fmt.Errorf("blocked subject %q is not a DID", b.Subject)
+	}
+	if err := checkDid(b.Subject); err != nil {
+		return blockRecord{}, fmt.Errorf("blocked subject: %w", err)
+	}
+	return blockRecord{subject: b.Subject, rkey: rkey, createdAt: b.CreatedAt}, nil
+}
+
+// listMyBlocks returns the block records in our authenticated user's own repo.
+func listMyBlocks(xrpcc *xrpc.Client) ([]blockRecord, error) {
+	records, warnings, err := listBlockRecords(xrpcc, xrpcc.Auth.Did)
+	if err != nil {
+		return nil, fmt.Errorf("list my blocks: %w", err)
+	}
+	printWarnings(warnings)
+	return records, nil
+}
+
+// listBlockRecords returns the block records in the repo of the user with did, a page at a time.
+// Malformed records are skipped and returned as warnings.
+func listBlockRecords(xrpcc *xrpc.Client, did string) ([]blockRecord, []recordWarning, error) {
+	// This is comatproto.RepoListRecords, except that we decode each record ourselves,
+	// so that one malformed record does not spoil the whole page.
+	var records []blockRecord
+	var warnings []recordWarning
+	var cursor string
+	for {
+		var out struct {
+			Cursor  *string `json:"cursor,omitempty"`
+			Records []struct {
+				Uri   string          `json:"uri"`
+				Value json.RawMessage `json:"value"`
+			} `json:"records"`
+		}
+		params := map[string]interface{}{
+			"collection": blockCollection,
+			"cursor":     cursor,
+			"limit":      100,
+			"repo":       did,
+		}
+		err := xrpcc.Do(context.TODO(), xrpc.Query, "", "com.atproto.repo.listRecords", params, nil, &out)
+		if err != nil {
+			return nil, nil, fmt.Errorf("list block records: %w", err)
+		}
+
+		for _, r := range out.Records {
+			// the uri is at://<did>/<collection>/<rkey>
+			rkey := r.Uri[strings.LastIndex(r.Uri, "/")+1:]
+			var b graphBlock
+			err := json.Unmarshal(r.Value, &b)
+			var rec blockRecord
+			if err == nil {
+				rec, err = newBlockRecord(rkey, &b)
+			}
+			if err != nil {
+				warnings = append(warnings, recordWarning{repo: did, rkey: rkey, err: err})
+				continue
+			}
+			records = append(records, rec)
+		}
+		if out.Cursor == nil || len(out.Records) == 0 {
+			break
+		}
+		cursor = *out.Cursor
+	}
+	return records, warnings, nil
+}
+
+// resolveHandles looks up the DIDs for handles, in the same order as handles.
+// When we are authenticated, we look them up in batches with getProfiles,
+// and otherwise, or for any handles it doesn't find, one at a time with resolveHandle.
+// Any of handles may instead be a DID, for which we look up the handle.
+// If fresh is set, we look up every handle again rather than trust our cache,
+// such as for users named on the command line that we are about to mute or block.
+func resolveHandles(xrpcc *xrpc.Client, handles []string, fresh bool) ([]resolvedUser, error) {
 	ctx := context.TODO()
+	rc := resolutions()
+	defer rc.save()
+
+	var dids, uncached []string
+	for _, handle := range handles {
+		if isDid(handle) {
+			if err := checkDid(handle); err != nil {
+				return nil, fmt.Errorf("resolve handles: %w", err)
+			}
+			dids = append(dids, handle)
+		} else if _, ok := rc.byHandle(handle); !ok || fresh {
+			uncached = append(uncached, handle)
+		}
+	}
+	byDid := make(map[string]resolvedUser)
+	for _, u := range resolveDids(xrpcc, dedup(dids)) {
+		byDid[u.did] = u
+	}
+	profiles, err := getProfiles(ctx, xrpcc, dedup(uncached))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v; resolving users one at a time instead\n", err)
+	}
+
 	var result []resolvedUser
 	for _, handle := range handles {
+		if u, ok := byDid[handle]; ok {
+			result = append(result, u)
+			continue
+		}
+		if u, ok := rc.byHandle(handle); ok && !fresh {
+			result = append(result, u)
+			continue
+		}
+		if p, ok := profiles[strings.ToLower(handle)]; ok {
+			u := resolvedUser{handle: handle, did: p.did, displayName: p.displayName}
+			rc.remember(u)
+			result = append(result, u)
+			continue
+		}
 		out, err := comatproto.IdentityResolveHandle(ctx, xrpcc, handle)
 		// TODO: consider allowing partial results?
 		if err != nil {
 			return nil, fmt.Errorf("resolve handles: %v: %w", handle, err)
 		}
-		result = append(result, resolvedUser{handle: handle, did: out.Did})
+		u := resolvedUser{handle: handle, did: out.Did}
+		rc.remember(u)
+		result = append(result, u)
 	}
 	return result, nil
 }
 
-func resolveDids(dids []string) ([]resolvedUser, error) {
-	ctx := context.TODO()
-	s := &api.PLCServer{ // TODO: probably reuse this?
-		Host: plcServer,
+// profileBatch is the most actors that app.bsky.actor.getProfiles accepts in one call.
+const profileBatch = 25
+
+// getProfiles looks up actors, which may be handles or DIDs, with app.bsky.actor.getProfiles,
+// profileBatch at a time. It returns the users it finds keyed by both DID and lowercased handle;
+// actors it doesn't find are simply missing. getProfiles requires authentication,
+// so if xrpcc is not authenticated, we find no one.
+func getProfiles(ctx context.Context, xrpcc *xrpc.Client, actors []string) (map[string]resolvedUser, error) {
+	found := make(map[string]resolvedUser)
+	if xrpcc.Auth == nil {
+		return found, nil
 	}
-	var result []resolvedUser
-	for _, did := range dids {
-		doc, err := s.GetDocument(ctx, did)
+	for len(actors) > 0 {
+		batch := actors
+		if len(batch) > profileBatch {
+			batch = batch[:profileBatch]
+		}
+		actors = actors[len(batch):]
+
+		out, err := actorGetProfiles(ctx, xrpcc, batch)
 		if err != nil {
-			return nil, err
+			return found, fmt.Errorf("get profiles: %w", err)
 		}
-		if len(doc.AlsoKnownAs) == 0 {
-			// TODO: probably get all AlsoKnownAs?
-			continue
+		for _, p := range out.Profiles {
+			if p.Handle == invalidHandle {
+				// Let the caller find out more some other way.
+				continue
+			}
+			u := resolvedUser{handle: p.Handle, did: p.Did, displayName: stringOrEmpty(p.DisplayName)}
+			found[p.Did] = u
+			found[strings.ToLower(p.Handle)] = u
 		}
-		handle := doc.AlsoKnownAs[0]
-		// TODO: should we confirm "at://" is present?
-		handle = strings.TrimPrefix(handle, "at://")
-		result = append(result, resolvedUser{handle: handle, did: did})
 	}
-	return result, nil
+	return found, nil
 }
 
-func muteUsers(xrpcc *xrpc.Client, dids []string) error {
-	// don't mute users that are already muted. might be friendlier to the server?
-	alreadyMuted, err := listMutes(xrpcc)
+// actorGetProfiles is like bsky.ActorGetProfiles, but sends actors as repeated query parameters,
+// as XRPC servers expect. (Our version of xrpc.Client joins them with commas,
+// which the server takes to be a single actor.)
+func actorGetProfiles(ctx context.Context, xrpcc *xrpc.Client, actors []string) (*bsky.ActorGetProfiles_Output, error) {
+	q := url.Values{"actors": actors}
+	var out bsky.ActorGetProfiles_Output
+	err := xrpcc.Do(ctx, xrpc.Query, "", "app.bsky.actor.getProfiles?"+q.Encode(), nil, nil, &out)
 	if err != nil {
-		return fmt.Errorf("check for already muted users: %w", err)
+		return nil, err
 	}
-	alreadyMutedDids := didsFromUsers(alreadyMuted)
+	return &out, nil
+}
 
-	// TODO: we should subtract based on dids, not did & handle
-	notYetMuted := subtract(dids, alreadyMutedDids)
-	switch {
-	case len(notYetMuted) == 0:
-		fmt.Printf("all %d users already muted, nothing more to do\n", len(dids))
-		return nil
-	case len(dids)-len(notYetMuted) > 0:
-		fmt.Printf("%d of %d users already muted\n", len(dids)-len(notYetMuted), len(dids))
+// invalidHandle is what Bluesky reports as the handle of a user whose handle doesn't check out.
+const invalidHandle = "handle.invalid"
+
+// resolveConcurrency and resolveRate are set by our --concurrency and --rate flags.
+var (
+	resolveConcurrency = 8
+	resolveRate        = 50.0 // PLC directory requests per second
+)
+
+var (
+	didOnce sync.Once
+	didc    *http.Client
+)
+
+// didClient returns our HTTP client for fetching DID documents, which is shared so that we reuse connections.
+func didClient() *http.Client {
+	didOnce.Do(func() {
+		didc = cliutil.NewHttpClient()
+	})
+	return didc
+}
+
+// resolveDids looks up the handles for dids, in the same order as dids.
+// We skip any DIDs we resolved recently enough to still be in our resolve cache.
+// When we are authenticated, we look up the rest in batches with getProfiles.
+// Otherwise, or for any DIDs it doesn't find, we fetch their DID documents, making up to
+// resolveConcurrency requests at once, and no more than resolveRate requests per second
+// to the PLC directory.
+//
+// Users we can't resolve to a handle, such as deleted accounts, are still returned,
+// with the placeholder handle invalidHandle and a status saying why.
+func resolveDids(xrpcc *xrpc.Client, dids []string) []resolvedUser {
+	ctx := context.TODO()
+	rc := resolutions()
+	defer rc.save()
+
+	users := make([]resolvedUser, len(dids))
+	done := make([]bool, len(dids))
+	var uncached []string
+	for i, did := range dids {
+		if u, ok := rc.byDid(did); ok {
+			users[i], done[i] = u, true
+			continue
+		}
+		uncached = append(uncached, did)
+	}
+	profiles, err := getProfiles(ctx, xrpcc, dedup(uncached))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v; using the PLC directory instead\n", err)
+	}
+	var todo []int
+	for i, did := range dids {
+		if done[i] {
+			continue
+		}
+		if u, ok := profiles[did]; ok {
+			rc.remember(u)
+			users[i] = u
+			continue
+		}
+		todo = append(todo, i)
+	}
+
+	hc := didClient()
+	limit := rate.Limit(resolveRate)
+	if resolveRate <= 0 {
+		limit = rate.Inf
+	}
+	limiter := rate.NewLimiter(limit, 1)
+	// If getProfiles didn't find a user, it may be because the account is deactivated,
+	// so we ask its PDS. Otherwise, that would be one request too many for every user.
+	checkRepos := xrpcc.Auth != nil && err == nil
+
+	workers := resolveConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Each worker stores its results by index, which keeps our output in order.
+	var mu sync.Mutex
+	var unresolved int
+	var firstErr error
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				u, err := resolveDid(ctx, hc, limiter, dids[i], checkRepos)
+				if err != nil {
+					mu.Lock()
+					unresolved++
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+				if u.status == "" {
+					rc.rememberHandle(u)
+				}
+				users[i] = u
+			}
+		}()
+	}
+	for _, i := range todo {
+		work <- i
 	}
+	close(work)
+	wg.Wait()
+	if firstErr != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not look up the DID documents for %d users: %v\n", unresolved, firstErr)
+	}
+	return users
+}
 
-	for _, did := range notYetMuted {
-		// fmt.Println("muting did:", u.did)
-		err := bsky.GraphMuteActor(context.TODO(),
-			xrpcc,
-			&bsky.GraphMuteActor_Input{Actor: did})
+// resolveDid looks up the handle for a DID in its DID document. If checkRepo is set,
+// it also asks the user's PDS whether their repo is active.
+// If we can't find a handle, u.handle is invalidHandle and u.status says why,
+// and err is set if that was because of an error fetching the DID document.
+func resolveDid(ctx context.Context, hc *http.Client, limiter *rate.Limiter, did string, checkRepo bool) (u resolvedUser, err error) {
+	u = resolvedUser{handle: invalidHandle, did: did}
+	if strings.HasPrefix(did, "did:plc:") {
+		err = limiter.Wait(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to mute: %s: %w", did, err)
+			u.status = statusUnresolved
+			return u, err
 		}
 	}
-	fmt.Printf("successfully muted %d users\n", len(notYetMuted))
-	return nil
+	doc, err := getDidDocument(ctx, hc, did)
+	var statusErr didStatusError
+	switch {
+	case errors.As(err, &statusErr):
+		u.status = statusErr.status
+		return u, nil
+	case err != nil:
+		u.status = statusUnresolved
+		return u, err
+	}
+	if h := doc.handle(); h != "" {
+		u.handle = h
+	} else {
+		u.status = statusNoHandle
+	}
+	if checkRepo && u.status == "" {
+		u.status = repoStatus(ctx, hc, doc, did)
+	}
+	return u, nil
 }
 
-func listBlocks(ctx context.Context, xrpcc *xrpc.Client, resolvedUsers []resolvedUser) (blockedUsers []resolvedUser, err error) {
+// listBlocks returns the users blocked by any of resolvedUsers,
+// along with which of resolvedUsers blocked them, keyed by the blocked DID.
+func listBlocks(ctx context.Context, xrpcc *xrpc.Client, resolvedUsers []resolvedUser) (blockedUsers []resolvedUser, blockedBy map[string][]resolvedUser, err error) {
 	seenDids := make(map[string]bool)
+	blockedBy = make(map[string][]resolvedUser)
 	for _, u := range resolvedUsers {
 		var blockedDids []string
-		repob, err := comatproto.SyncGetRepo(ctx, xrpcc, u.did, "", "")
-		if err != nil {
-			return nil, fmt.Errorf("list blocks for %v: %w", u.did, err)
-		}
-
-		rr, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(repob))
+		records, warnings, err := listUserBlocks(ctx, xrpcc, u.did)
 		if err != nil {
-			return nil, fmt.Errorf("list blocks for %v: %w", u.did, err)
+			return nil, nil, fmt.Errorf("list blocks for %v: %w", u.did, err)
 		}
-
-		// get the blocks
-		collection := "app.bsky.graph.block"
-		err = rr.ForEach(context.TODO(), collection, func(k string, v cid.Cid) error {
-			if !strings.HasPrefix(k, collection) {
-				return repo.ErrDoneIterating
-			}
-			// fmt.Print("k: ", k, "  ")
-			b, err := rr.Blockstore().Get(ctx, v)
-			if err != nil {
-				return fmt.Errorf("list blocks for %v: %w", u.did, err)
+		printWarnings(warnings)
+		for _, r := range records {
+			did := r.subject
+			// remember who blocked this did, and then dedup and store
+			if !slices.Contains(blockedBy[did], u) {
+				blockedBy[did] = append(blockedBy[did], u)
 			}
-
-			// TODO: probably rookie mistake, but for now, convert from cbor to json
-			// and pull what we need out of the json
-			convb, err := cborToJson(b.RawData())
-			if err != nil {
-				return fmt.Errorf("list blocks for %v: %w", u.did, err)
-			}
-			// fmt.Println(string(convb))
-
-			var data map[string]any
-			err = json.Unmarshal(convb, &data)
-			if err != nil {
-				return fmt.Errorf("list blocks for %v: %w", u.did, err)
-			}
-			did, ok := data["subject"].(string)
-			if !ok {
-				return fmt.Errorf("unexpected blocked subject %T: %v", data["subject"], data["subject"])
-			}
-
-			// dedup and store
 			if !seenDids[did] {
 				// TODO: add a test that sees duplicate dids
 				blockedDids = append(blockedDids, did)
 				seenDids[did] = true
 			}
-			return nil
-		})
-
-		// Done getting the blocks for this user.
-		// TODO: consider emitting partial results when error (here, below)?
-		if err != nil {
-			return nil, fmt.Errorf("list blocks for %v: %w", u.did, err)
 		}
 
 		// TODO: resolveDids might be more expensive than some other things?
-		resolvedUsers, err := resolveDids(blockedDids)
+		blockedUsers = append(blockedUsers, resolveDids(xrpcc, blockedDids)...)
+	}
+	return blockedUsers, blockedBy, nil
+}
+
+// listUserBlocks returns the block records of the user with did, from the PDS that hosts their repo,
+// or if we can't find it, from xrpcc's. We page through their block records, which is much cheaper
+// for large repos than downloading the whole repo. If the server can't list the records,
+// we fall back to the repo.
+func listUserBlocks(ctx context.Context, xrpcc *xrpc.Client, did string) ([]blockRecord, []recordWarning, error) {
+	pdsc, err := pdsClient(ctx, did)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v; trying %s instead\n", err, xrpcc.Host)
+		pdsc = xrpcc
+	}
+	records, warnings, err := listBlockRecords(pdsc, did)
+	if err == nil {
+		return records, warnings, nil
+	}
+	fmt.Fprintf(os.Stderr, "warning: %v; downloading the repo for %s instead\n", err, did)
+	return listBlockRecordsFromRepo(ctx, pdsc, did)
+}
+
+var (
+	pdsMu      sync.Mutex
+	pdsClients = make(map[string]*xrpc.Client) // keyed by the PDS URL
+)
+
+// pdsClient returns a client for the PDS that hosts the repo of the user with did,
+// as listed in their DID document. We keep one client per PDS, so that users on the same
+// PDS share connections. The clients are not authenticated, so that we never send
+// our credentials to some other user's PDS.
+func pdsClient(ctx context.Context, did string) (*xrpc.Client, error) {
+	rc := resolutions()
+	host, ok := rc.pds(did)
+	if !ok {
+		doc, err := getDidDocument(ctx, didClient(), did)
 		if err != nil {
-			return nil, fmt.Errorf("list blocks for %v: %w", u.did, err)
+			return nil, fmt.Errorf("find PDS for %s: %w", did, err)
+		}
+		host = strings.TrimSuffix(doc.pds(), "/")
+		if host == "" {
+			return nil, fmt.Errorf("find PDS for %s: its DID document lists none", did)
+		}
+		u, err := url.Parse(host)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("find PDS for %s: bad service endpoint %q", did, host)
 		}
-		blockedUsers = append(blockedUsers, resolvedUsers...)
+		rc.rememberPds(did, host)
+		rc.save()
 	}
-	return blockedUsers, nil
+
+	pdsMu.Lock()
+	defer pdsMu.Unlock()
+	c, ok := pdsClients[host]
+	if !ok {
+		c = &xrpc.Client{Client: cliutil.NewHttpClient(), Host: host}
+		pdsClients[host] = c
+	}
+	return c, nil
 }
 
-func didsFromUsers(users []resolvedUser) (dids []string) {
-	for _, u := range users {
-		dids = append(dids, u.did)
+// listBlockRecordsFromRepo returns the block records of the user with did,
+// by downloading their whole repo as a CAR file.
+func listBlockRecordsFromRepo(ctx context.Context, xrpcc *xrpc.Client, did string) ([]blockRecord, []recordWarning, error) {
+	repob, err := comatproto.SyncGetRepo(ctx, xrpcc, did, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rr, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(repob))
+	if err != nil {
+		return nil, nil, err
 	}
-	return dids
+
+	// get the blocks
+	var records []blockRecord
+	var warnings []recordWarning
+	err = rr.ForEach(ctx, blockCollection, func(k string, v cid.Cid) error {
+		if !strings.HasPrefix(k, blockCollection+"/") {
+			return repo.ErrDoneIterating
+		}
+		rkey := strings.TrimPrefix(k, blockCollection+"/")
+		b, err := rr.Blockstore().Get(ctx, v)
+		if err != nil {
+			return err
+		}
+
+		var gb graphBlock
+		err = gb.UnmarshalCBOR(bytes.NewReader(b.RawData()))
+		var rec blockRecord
+		if err == nil {
+			rec, err = newBlockRecord(rkey, &gb)
+		}
+		if err != nil {
+			warnings = append(warnings, recordWarning{repo: did, rkey: rkey, err: err})
+			return nil
+		}
+		records = append(records, rec)
+		return nil
+	})
+	// TODO: consider emitting partial results when error?
+	if err != nil {
+		return nil, nil, err
+	}
+	return records, warnings, nil
 }
 
 func trimAts(handles []string) []string {
@@ -352,15 +1427,60 @@ func printResolvedUsers(c *cli.Context, resolvedUsers []resolvedUser) {
 			}
 			fmt.Print("@" + u.handle)
 		case c.Bool("verbose"):
-			// TODO: display name?
-			fmt.Println(u.did, "@"+u.handle)
+			line := u.did + " @" + u.handle
+			if u.displayName != "" {
+				line += fmt.Sprintf(" %q", u.displayName)
+			}
+			if u.status != "" {
+				line += " (" + u.status + ")"
+			}
+			fmt.Println(line)
+		case u.status != "":
+			fmt.Printf("@%s (%s)\n", u.handle, u.status)
 		default:
 			fmt.Println("@" + u.handle)
 		}
 	}
 }
 
-func parseUserList(r io.Reader) (dids []string, err error) {
+// readUserListFile reads the users listed in a go-mod-user-list file.
+func readUserListFile(filename string) ([]resolvedUser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users, err := parseUserList(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	return users, nil
+}
+
+// fetchUserList fetches the users listed in a go-mod-user-list served at url.
+func fetchUserList(client *http.Client, url string) ([]resolvedUser, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching url: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("resource not found: %s", url)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status code %d when fetching %s", resp.StatusCode, url)
+	}
+	users, err := parseUserList(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", url, err)
+	}
+	return users, nil
+}
+
+// parseUserList parses a go-mod-user-list, which has a DID at the start of each line,
+// optionally followed by the user's @handle. The handles are as listed, and not verified.
+func parseUserList(r io.Reader) (users []resolvedUser, err error) {
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -377,48 +1497,30 @@ func parseUserList(r io.Reader) (dids []string, err error) {
 		if did == "" {
 			did = line
 		}
-		if !strings.HasPrefix(did, "did:plc:") {
-			return nil, fmt.Errorf("bad DID in go-mod-user-list on line: %s", line)
+		if err := checkDid(did); err != nil {
+			return nil, fmt.Errorf("bad DID in go-mod-user-list on line: %s: %w", line, err)
+		}
+		var handle string
+		if rest := strings.Fields(line[len(did):]); len(rest) > 0 && strings.HasPrefix(rest[0], "@") {
+			handle = rest[0][1:]
 		}
-		dids = append(dids, did)
+		users = append(users, resolvedUser{handle: handle, did: did})
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
-	return dids, nil
+	return users, nil
 }
 
 // borrowed from indigo/gosky
-func cborToJson(data []byte) ([]byte, error) {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("panic: ", r)
-			fmt.Printf("bad blob: %x\n", data)
-		}
-	}()
-	buf := new(bytes.Buffer)
-	enc := rejson.NewEncoder(buf, rejson.EncodeOptions{})
-
-	dec := cbor.NewDecoder(cbor.DecodeOptions{}, bytes.NewReader(data))
-	err := shared.TokenPump{TokenSource: dec, TokenSink: enc}.Run()
-	if err != nil {
-		return nil, err
-	}
-
-	return buf.Bytes(), nil
-}
-
-// subtract does an order preserving set subtraction,
-// removing from a any common elements in b.
-func subtract[T comparable](a, b []T) []T {
+// dedup does an order preserving removal of duplicate elements.
+func dedup[T comparable](a []T) []T {
 	res := []T{}
-	inB := map[T]bool{}
-	for _, v := range b {
-		inB[v] = true
-	}
+	seen := map[T]bool{}
 	for _, v := range a {
-		if !inB[v] {
+		if !seen[v] {
 			res = append(res, v)
+			seen[v] = true
 		}
 	}
 	return res
@@ -430,3 +1532,11 @@ func subtract[T comparable](a, b []T) []T {
 // 	}
 // 	return *s
 // }
+
+// stringOrEmpty returns *s, or "" if s is nil.
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A config declares the sources we trust, whether each one mutes or blocks,
+// and the users we never mute or block. It is read from a YAML file, such as:
+//
+//	trusted:
+//	  - user: "@user1.bsky.social"
+//	  - url: https://example.com/a-list-of-users.txt
+//	    action: block
+//	  - file: users.txt
+//	    weight: 0.5
+//	threshold: 1
+//	allow:
+//	  - "@friend.bsky.social"
+//	  - did:plc:abcdefghijklmnopqrstuvwx
+//	allowlist: friends.txt
+//
+// A user's score is the sum of the weights of the sources that list them,
+// and only users with a score of at least the threshold are muted or blocked.
+// Mutes and blocks are scored separately.
+type config struct {
+	Trusted   []trustedSource `yaml:"trusted"`
+	Threshold *float64        `yaml:"threshold"` // the default is 1
+	Allow     []string        `yaml:"allow"`     // handles or DIDs
+	Allowlist string          `yaml:"allowlist"` // a go-mod-user-list file, relative to the config file
+}
+
+// threshold returns the minimum score for a user to be muted or blocked.
+func (cfg *config) threshold() float64 {
+	if cfg.Threshold == nil {
+		return 1
+	}
+	return *cfg.Threshold
+}
+
+// A trustedSource is a user whose blocks we import, or a file or URL with a go-mod-user-list.
+// Exactly one of User, File, or URL is set.
+type trustedSource struct {
+	User   string   `yaml:"user"`
+	File   string   `yaml:"file"` // relative paths are relative to the config file
+	URL    string   `yaml:"url"`
+	Action string   `yaml:"action"` // mute or block. The default is mute, which is private.
+	Weight *float64 `yaml:"weight"` // the default is 1
+}
+
+// weight returns how much this source counts toward a user's score.
+func (ts trustedSource) weight() float64 {
+	if ts.Weight == nil {
+		return 1
+	}
+	return *ts.Weight
+}
+
+// action returns the verb for the moderation this source asks for.
+func (ts trustedSource) action() string {
+	if ts.Action == "" {
+		return muting.verb
+	}
+	return ts.Action
+}
+
+// readConfig reads and validates a config file.
+func readConfig(filename string) (*config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var cfg config
+	err = dec.Decode(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+
+	dir := filepath.Dir(filename)
+	for i := range cfg.Trusted {
+		ts := &cfg.Trusted[i]
+		n := 0
+		for _, s := range []string{ts.User, ts.File, ts.URL} {
+			if s != "" {
+				n++
+			}
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("parsing %s: trusted source %d must have exactly one of user, file, or url", filename, i+1)
+		}
+		switch ts.Action {
+		case "", muting.verb, blocking.verb:
+		default:
+			return nil, fmt.Errorf("parsing %s: trusted source %d: action must be mute or block, not %q", filename, i+1, ts.Action)
+		}
+		if ts.weight() < 0 {
+			return nil, fmt.Errorf("parsing %s: trusted source %d: weight must not be negative", filename, i+1)
+		}
+		if ts.File != "" && !filepath.IsAbs(ts.File) {
+			ts.File = filepath.Join(dir, ts.File)
+		}
+	}
+	if cfg.Allowlist != "" && !filepath.IsAbs(cfg.Allowlist) {
+		cfg.Allowlist = filepath.Join(dir, cfg.Allowlist)
+	}
+	if cfg.threshold() <= 0 {
+		return nil, fmt.Errorf("parsing %s: threshold must be greater than 0", filename)
+	}
+	return &cfg, nil
+}
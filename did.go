@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Statuses for users we could not fully resolve. We still moderate them by DID,
+// but show their status alongside the placeholder handle invalidHandle.
+const (
+	statusNoHandle    = "no handle"   // the DID document lists no handle
+	statusDeactivated = "deactivated" // the account's repo is deactivated
+	statusTakendown   = "taken down"  // the account's repo was taken down by its PDS
+	statusTombstoned  = "tombstoned"  // the DID was deleted from the PLC directory
+	statusNotFound    = "not found"   // the DID or its repo does not exist
+	statusUnresolved  = "unresolved"  // we could not fetch the DID document
+	statusUnsupported = "unsupported" // we can't resolve DIDs that use its method
+)
+
+// didPattern matches the generic DID syntax: "did:", a lowercase method name, ":",
+// and an identifier of letters, digits, and ._:%- that does not end in ":" or "%".
+var didPattern = regexp.MustCompile(`^did:[a-z]+:[a-zA-Z0-9._:%-]*[a-zA-Z0-9._-]$`)
+
+// maxDidLength is the longest DID that atproto allows.
+const maxDidLength = 2048
+
+// isDid reports whether s is meant as a DID rather than a handle. It might not be a valid one.
+func isDid(s string) bool {
+	return strings.HasPrefix(s, "did:")
+}
+
+// checkDid returns an error if s is not a valid DID.
+// We accept any DID method, though we can only resolve did:plc and did:web.
+func checkDid(s string) error {
+	if len(s) > maxDidLength {
+		return fmt.Errorf("invalid DID %.40q...: longer than %d characters", s, maxDidLength)
+	}
+	if !didPattern.MatchString(s) {
+		return fmt.Errorf("invalid DID %q", s)
+	}
+	if strings.HasPrefix(s, "did:web:") {
+		_, err := didWebURL(s)
+		return err
+	}
+	return nil
+}
+
+// didWebURL returns the URL of the DID document for a did:web DID.
+// Like the reference atproto implementation, we only accept a hostname, optionally
+// with an encoded port, and use plain HTTP only for localhost.
+func didWebURL(did string) (string, error) {
+	id := strings.TrimPrefix(did, "did:web:")
+	if strings.Contains(id, ":") {
+		return "", fmt.Errorf("invalid DID %q: did:web DIDs with a path are not supported", did)
+	}
+	host, err := url.PathUnescape(id)
+	if err != nil {
+		return "", fmt.Errorf("invalid DID %q: %w", did, err)
+	}
+	u, err := url.Parse("https://" + host)
+	if err != nil || u.Host != host || u.Hostname() == "" {
+		return "", fmt.Errorf("invalid DID %q: %q is not a hostname", did, host)
+	}
+	if u.Hostname() == "localhost" {
+		u.Scheme = "http"
+	}
+	u.Path = "/.well-known/did.json"
+	return u.String(), nil
+}
+
+// didDocument is the part of a DID document that we use.
+type didDocument struct {
+	ID          string       `json:"id"`
+	AlsoKnownAs []string     `json:"alsoKnownAs"`
+	Service     []didService `json:"service"`
+}
+
+type didService struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	ServiceEndpoint string `json:"serviceEndpoint"`
+}
+
+// handle returns the handle the document claims, or "" if it claims none.
+func (doc *didDocument) handle() string {
+	for _, aka := range doc.AlsoKnownAs {
+		if h, ok := strings.CutPrefix(aka, "at://"); ok {
+			return h
+		}
+	}
+	return ""
+}
+
+// pds returns the URL of the account's personal data server, or "" if the document lists none.
+func (doc *didDocument) pds() string {
+	for _, s := range doc.Service {
+		if strings.HasSuffix(s.ID, "#atproto_pds") && s.Type == "AtprotoPersonalDataServer" {
+			return s.ServiceEndpoint
+		}
+	}
+	return ""
+}
+
+// A didStatusError reports that there is no document for a DID,
+// along with the status that implies.
+type didStatusError struct {
+	did    string
+	status string
+}
+
+func (e didStatusError) Error() string {
+	return fmt.Sprintf("%s is %s", e.did, e.status)
+}
+
+// getDidDocument fetches the DID document for did, from the PLC directory for did:plc,
+// or from the DID's host for did:web. If there is no document because the DID is unknown
+// or tombstoned, or because we can't resolve its method, the error is a didStatusError.
+func getDidDocument(ctx context.Context, hc *http.Client, did string) (*didDocument, error) {
+	var docURL string
+	switch {
+	case strings.HasPrefix(did, "did:plc:"):
+		docURL = plcServer + "/" + did
+	case strings.HasPrefix(did, "did:web:"):
+		var err error
+		docURL, err = didWebURL(did)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, didStatusError{did, statusUnsupported}
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", docURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, didStatusError{did, statusNotFound}
+	case http.StatusGone:
+		return nil, didStatusError{did, statusTombstoned}
+	default:
+		return nil, fmt.Errorf("get DID document for %s: %s", did, resp.Status)
+	}
+	var doc didDocument
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("get DID document for %s: %w", did, err)
+	}
+	if doc.ID != did {
+		// A did:web host might serve some other DID's document.
+		return nil, fmt.Errorf("get DID document for %s: got the document for %q", did, doc.ID)
+	}
+	return &doc, nil
+}
+
+// repoStatus asks the account's PDS about the repo for did, returning a status such as
+// statusDeactivated, or "" if the repo is active or the PDS doesn't say.
+func repoStatus(ctx context.Context, hc *http.Client, doc *didDocument, did string) string {
+	pds := doc.pds()
+	if pds == "" {
+		return ""
+	}
+	// We use plain HTTP here rather than xrpc.Client, which drops the error names we need.
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		strings.TrimSuffix(pds, "/")+"/xrpc/com.atproto.sync.getRepoStatus?did="+url.QueryEscape(did), nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Active *bool  `json:"active"`
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case out.Error == "RepoNotFound":
+		return statusNotFound
+	case out.Error == "RepoDeactivated", out.Status == "deactivated":
+		return statusDeactivated
+	case out.Error == "RepoTakendown", out.Status == "takendown":
+		return statusTakendown
+	case out.Active != nil && !*out.Active && out.Status != "":
+		return out.Status
+	}
+	return ""
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// fakeBluesky is an in-process fake of the parts of a Bluesky PDS and PLC directory that gomoderate uses,
+// so that our testscripts can run without network access or a real account.
+// It is seeded from a simple line-oriented fixture, usually a file in the testscript, for example:
+//
+//	# comments and blank lines are ignored
+//	user did:plc:me me.test xyz        # a DID, handle, and optional app key
+//	user did:plc:spammer spammer.test
+//	user did:web:fake web.test         # did:web:fake is a did:web DID whose document we serve
+//	name did:plc:spammer Spammer One   # a display name
+//	block did:plc:me did:plc:spammer   # did:plc:me blocks did:plc:spammer
+//	mute did:plc:me did:plc:spammer    # did:plc:me mutes did:plc:spammer
+//	follow did:plc:me did:plc:friend   # did:plc:me follows did:plc:friend
+//	list spam.txt did:plc:spammer spammer.test  # a user list served at /lists/spam.txt
+//	nogetprofiles                      # getProfiles fails, as on an older server
+//	nolistrecords did:plc:old          # listRecords fails for this repo, as on an older server
+//	nohandle did:plc:old               # the DID document lists no handle
+//	tombstoned did:plc:old             # the PLC directory says the DID is gone
+//	deactivated did:plc:old            # the repo is deactivated
+//	elsewhere did:plc:old              # the repo is hosted on a second PDS
+type fakeBluesky struct {
+	mu      sync.Mutex
+	srv     *httptest.Server
+	users   map[string]*fakeUser // keyed by DID
+	handles map[string]string    // handle to DID
+	lists   map[string]string    // user lists, keyed by name
+	log     string               // if set, a file to which we append each xrpc method and PLC lookup
+
+	noGetProfiles bool   // if set, app.bsky.actor.getProfiles is not implemented
+	webDid        string // the did:web DID whose document we serve at /.well-known/did.json
+
+	other *httptest.Server // another PDS, which hosts the repos of users marked elsewhere
+}
+
+type fakeUser struct {
+	did         string
+	handle      string
+	displayName string
+	appKey      string
+	mutes       []string
+	follows     []string
+	blocks      []fakeBlock
+
+	noListRecords bool
+	noHandle      bool // the DID document lists no handle
+	tombstoned    bool // the PLC directory says the DID is gone
+	deactivated   bool // the repo is deactivated, so the account has no profile
+	elsewhere     bool // the repo is hosted on our other PDS
+}
+
+type fakeBlock struct {
+	rkey      string
+	subject   string
+	createdAt string
+}
+
+var fakeJwtKey = []byte("fake bluesky signing key")
+
+func newFakeBluesky(fixture, log string) (*fakeBluesky, error) {
+	fb := &fakeBluesky{
+		log:     log,
+		users:   make(map[string]*fakeUser),
+		handles: make(map[string]string),
+		lists:   make(map[string]string),
+	}
+	// The fixture may use did:web:fake for a did:web user whose DID document we serve.
+	fb.srv = httptest.NewUnstartedServer(fb)
+	fb.other = httptest.NewUnstartedServer(fb)
+	_, port, _ := net.SplitHostPort(fb.srv.Listener.Addr().String())
+	fb.webDid = "did:web:localhost%3A" + port
+	fixture = strings.ReplaceAll(fixture, "did:web:fake", fb.webDid)
+	scanner := bufio.NewScanner(strings.NewReader(fixture))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "user" && (len(fields) == 3 || len(fields) == 4):
+			u := &fakeUser{did: fields[1], handle: fields[2]}
+			if len(fields) == 4 {
+				u.appKey = fields[3]
+			}
+			fb.users[u.did] = u
+			fb.handles[u.handle] = u.did
+		case (fields[0] == "block" || fields[0] == "mute" || fields[0] == "follow") && len(fields) == 3:
+			u, ok := fb.users[fields[1]]
+			if !ok {
+				return nil, fmt.Errorf("fixture line %d: unknown user %s", line, fields[1])
+			}
+			switch fields[0] {
+			case "block":
+				u.addBlock(fields[2])
+			case "mute":
+				u.mutes = append(u.mutes, fields[2])
+			case "follow":
+				u.follows = append(u.follows, fields[2])
+			}
+		case fields[0] == "list" && (len(fields) == 3 || len(fields) == 4):
+			fb.lists[fields[1]] += fields[2]
+			if len(fields) == 4 {
+				fb.lists[fields[1]] += " @" + fields[3]
+			}
+			fb.lists[fields[1]] += "\n"
+		case fields[0] == "name" && len(fields) >= 3:
+			u, ok := fb.users[fields[1]]
+			if !ok {
+				return nil, fmt.Errorf("fixture line %d: unknown user %s", line, fields[1])
+			}
+			u.displayName = strings.Join(fields[2:], " ")
+		case fields[0] == "nogetprofiles" && len(fields) == 1:
+			fb.noGetProfiles = true
+		case (fields[0] == "nolistrecords" || fields[0] == "nohandle" || fields[0] == "tombstoned" || fields[0] == "deactivated" || fields[0] == "elsewhere") && len(fields) == 2:
+			u, ok := fb.users[fields[1]]
+			if !ok {
+				return nil, fmt.Errorf("fixture line %d: unknown user %s", line, fields[1])
+			}
+			switch fields[0] {
+			case "nolistrecords":
+				u.noListRecords = true
+			case "nohandle":
+				u.noHandle = true
+			case "tombstoned":
+				u.tombstoned = true
+			case "deactivated":
+				u.deactivated = true
+			case "elsewhere":
+				u.elsewhere = true
+			}
+		default:
+			return nil, fmt.Errorf("fixture line %d: bad line: %s", line, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	fb.srv.Start()
+	fb.other.Start()
+	return fb, nil
+}
+
+func (u *fakeUser) addBlock(subject string) string {
+	rkey := fmt.Sprintf("3jblock%06d", len(u.blocks)+1)
+	for _, b := range u.blocks {
+		if b.rkey >= rkey {
+			n, _ := strconv.Atoi(strings.TrimPrefix(b.rkey, "3jblock"))
+			rkey = fmt.Sprintf("3jblock%06d", n+1)
+		}
+	}
+	u.blocks = append(u.blocks, fakeBlock{rkey: rkey, subject: subject, createdAt: "2023-05-01T00:00:00.000Z"})
+	return rkey
+}
+
+func (fb *fakeBluesky) Close() {
+	fb.srv.Close()
+	fb.other.Close()
+}
+
+func (fb *fakeBluesky) URL() string { return fb.srv.URL }
+
+func (fb *fakeBluesky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/did:plc:") {
+		fb.logCall("plc " + strings.TrimPrefix(r.URL.Path, "/"))
+		fb.didDocument(w, strings.TrimPrefix(r.URL.Path, "/"))
+		return
+	}
+	if r.URL.Path == "/.well-known/did.json" {
+		fb.logCall("web " + fb.webDid)
+		fb.didDocument(w, fb.webDid)
+		return
+	}
+	if name, ok := strings.CutPrefix(r.URL.Path, "/lists/"); ok {
+		list, ok := fb.lists[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, list)
+		return
+	}
+	method, ok := strings.CutPrefix(r.URL.Path, "/xrpc/")
+	if !ok {
+		fakeError(w, http.StatusNotFound, "NotFound", "not found")
+		return
+	}
+	// Requests to our other PDS are logged as "other <method>".
+	other := r.Host == fb.other.Listener.Addr().String()
+	if other {
+		fb.logCall("other " + method)
+	} else {
+		fb.logCall(method)
+	}
+	q := r.URL.Query()
+	switch method {
+	case "com.atproto.server.createSession":
+		var in struct{ Identifier, Password string }
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+			return
+		}
+		u := fb.lookup(strings.TrimPrefix(in.Identifier, "@"))
+		if u == nil || u.appKey == "" || u.appKey != in.Password {
+			fakeError(w, http.StatusUnauthorized, "AuthenticationRequired", "Invalid identifier or password")
+			return
+		}
+		fakeJSON(w, map[string]any{
+			"accessJwt":  fakeToken(u.did, "com.atproto.appPass"),
+			"refreshJwt": fakeToken(u.did, "com.atproto.refresh"),
+			"handle":     u.handle,
+			"did":        u.did,
+		})
+	case "com.atproto.identity.resolveHandle":
+		u := fb.lookup(q.Get("handle"))
+		if u == nil {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest", "Unable to resolve handle")
+			return
+		}
+		fakeJSON(w, map[string]any{"did": u.did})
+	case "app.bsky.actor.getProfiles":
+		if fb.noGetProfiles {
+			fakeError(w, http.StatusNotImplemented, "MethodNotImplemented", "Method Not Implemented")
+			return
+		}
+		if fb.authed(w, r) == nil {
+			return
+		}
+		actors := q["actors"]
+		if len(actors) > 25 {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest", "Input/actors must not have more than 25 elements")
+			return
+		}
+		views := []any{}
+		for _, actor := range actors {
+			if u := fb.lookup(actor); u != nil && !u.deactivated && !u.tombstoned {
+				views = append(views, fb.profileView(u.did))
+			}
+		}
+		fakeJSON(w, map[string]any{"profiles": views})
+	case "com.atproto.sync.getRepoStatus":
+		u := fb.users[q.Get("did")]
+		switch {
+		case u == nil || u.tombstoned:
+			fakeError(w, http.StatusBadRequest, "RepoNotFound", "Could not find repo")
+		case u.deactivated:
+			fakeJSON(w, map[string]any{"did": u.did, "active": false, "status": "deactivated"})
+		default:
+			fakeJSON(w, map[string]any{"did": u.did, "active": true})
+		}
+	case "app.bsky.graph.getMutes":
+		me := fb.authed(w, r)
+		if me == nil {
+			return
+		}
+		var views []any
+		for _, did := range me.mutes {
+			views = append(views, fb.profileView(did))
+		}
+		page, cursor := fakePage(views, q)
+		fakeJSON(w, map[string]any{"mutes": page, "cursor": cursor})
+	case "app.bsky.graph.getFollows":
+		u := fb.lookup(q.Get("actor"))
+		if u == nil {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest", "Profile not found")
+			return
+		}
+		var views []any
+		for _, did := range u.follows {
+			views = append(views, fb.profileView(did))
+		}
+		page, cursor := fakePage(views, q)
+		fakeJSON(w, map[string]any{"follows": page, "cursor": cursor, "subject": fb.profileView(u.did)})
+	case "app.bsky.graph.muteActor", "app.bsky.graph.unmuteActor":
+		me := fb.authed(w, r)
+		if me == nil {
+			return
+		}
+		var in struct{ Actor string }
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+			return
+		}
+		u := fb.lookup(in.Actor)
+		if u == nil {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest", "Actor not found")
+			return
+		}
+		var mutes []string
+		for _, did := range me.mutes {
+			if did != u.did {
+				mutes = append(mutes, did)
+			}
+		}
+		if method == "app.bsky.graph.muteActor" {
+			mutes = append(mutes, u.did)
+		}
+		me.mutes = mutes
+		w.WriteHeader(http.StatusOK)
+	case "com.atproto.repo.listRecords":
+		u := fb.lookup(q.Get("repo"))
+		if u == nil || u.elsewhere != other {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest", "Could not find repo")
+			return
+		}
+		if u.noListRecords {
+			fakeError(w, http.StatusNotImplemented, "MethodNotImplemented", "Method Not Implemented")
+			return
+		}
+		var records []any
+		if q.Get("collection") == blockCollection {
+			for _, b := range u.blocks {
+				records = append(records, map[string]any{
+					"uri":   "at://" + u.did + "/" + blockCollection + "/" + b.rkey,
+					"cid":   "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm",
+					"value": map[string]any{"$type": blockCollection, "subject": b.subject, "createdAt": b.createdAt},
+				})
+			}
+		}
+		page, cursor := fakePage(records, q)
+		fakeJSON(w, map[string]any{"records": page, "cursor": cursor})
+	case "com.atproto.repo.createRecord":
+		me := fb.authed(w, r)
+		if me == nil {
+			return
+		}
+		var in struct {
+			Collection string
+			Repo       string
+			Record     struct{ Subject, CreatedAt string }
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Collection != blockCollection || in.Repo != me.did {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest", "unsupported record")
+			return
+		}
+		rkey := me.addBlock(in.Record.Subject)
+		fakeJSON(w, map[string]any{"uri": "at://" + me.did + "/" + blockCollection + "/" + rkey, "cid": "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"})
+	case "com.atproto.repo.deleteRecord":
+		me := fb.authed(w, r)
+		if me == nil {
+			return
+		}
+		var in struct{ Collection, Repo, Rkey string }
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Collection != blockCollection || in.Repo != me.did {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest", "unsupported record")
+			return
+		}
+		var blocks []fakeBlock
+		for _, b := range me.blocks {
+			if b.rkey != in.Rkey {
+				blocks = append(blocks, b)
+			}
+		}
+		me.blocks = blocks
+		w.WriteHeader(http.StatusOK)
+	case "com.atproto.sync.getRepo":
+		u := fb.lookup(q.Get("did"))
+		if u == nil || u.elsewhere != other {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest", "Could not find repo")
+			return
+		}
+		car, err := u.repoCar(r.Context())
+		if err != nil {
+			fakeError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.ipld.car")
+		w.Write(car)
+	default:
+		fakeError(w, http.StatusNotImplemented, "MethodNotImplemented", "Method Not Implemented")
+	}
+}
+
+// lookup finds a user by DID or handle.
+func (fb *fakeBluesky) lookup(actor string) *fakeUser {
+	if did, ok := fb.handles[actor]; ok {
+		actor = did
+	}
+	return fb.users[actor]
+}
+
+// authed returns the authenticated user, or writes an error.
+func (fb *fakeBluesky) authed(w http.ResponseWriter, r *http.Request) *fakeUser {
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok {
+		token, err := jwt.Parse(tokenString, func(*jwt.Token) (any, error) { return fakeJwtKey, nil })
+		if err == nil {
+			if sub, err := token.Claims.GetSubject(); err == nil && fb.users[sub] != nil {
+				return fb.users[sub]
+			}
+		}
+	}
+	fakeError(w, http.StatusUnauthorized, "AuthenticationRequired", "Authentication Required")
+	return nil
+}
+
+func (fb *fakeBluesky) profileView(did string) map[string]any {
+	view := map[string]any{"did": did, "handle": "handle.invalid", "viewer": map[string]any{}}
+	if u := fb.users[did]; u != nil {
+		view["handle"] = u.handle
+		if u.displayName != "" {
+			view["displayName"] = u.displayName
+		}
+	}
+	return view
+}
+
+// logCall appends a line to our log of requests, if we have one.
+func (fb *fakeBluesky) logCall(call string) {
+	if fb.log == "" {
+		return
+	}
+	f, err := os.OpenFile(fb.log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o666)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, call)
+	f.Close()
+}
+
+// didDocument serves a DID document, like the PLC directory or a did:web host.
+func (fb *fakeBluesky) didDocument(w http.ResponseWriter, did string) {
+	u := fb.users[did]
+	if u == nil {
+		fakeError(w, http.StatusNotFound, "NotFound", "DID not registered")
+		return
+	}
+	if u.tombstoned {
+		fakeError(w, http.StatusGone, "NotAvailable", "DID not available")
+		return
+	}
+	pds := fb.srv.URL
+	if u.elsewhere {
+		pds = fb.other.URL
+	}
+	aka := []string{"at://" + u.handle}
+	if u.noHandle {
+		aka = []string{}
+	}
+	fakeJSON(w, map[string]any{
+		"@context":    []string{"https://www.w3.org/ns/did/v1"},
+		"id":          u.did,
+		"alsoKnownAs": aka,
+		"service": []any{map[string]any{
+			"id":              "#atproto_pds",
+			"type":            "AtprotoPersonalDataServer",
+			"serviceEndpoint": pds,
+		}},
+	})
+}
+
+// repoCar generates a CAR file holding the user's repo.
+func (u *fakeUser) repoCar(ctx context.Context) ([]byte, error) {
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	rr := repo.NewRepo(ctx, u.did, bs)
+	for _, b := range u.blocks {
+		_, err := rr.PutRecord(ctx, blockCollection+"/"+b.rkey, &graphBlock{Subject: b.subject, CreatedAt: b.createdAt})
+		if err != nil {
+			return nil, err
+		}
+	}
+	root, err := rr.Commit(ctx, func(context.Context, string, []byte) ([]byte, error) {
+		return []byte("fake signature"), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Write a CARv1: a header naming the root, followed by every block.
+	buf := new(bytes.Buffer)
+	hdr := new(bytes.Buffer)
+	cw := cbg.NewCborWriter(hdr)
+	if err := cw.WriteMajorTypeHeader(cbg.MajMap, 2); err != nil {
+		return nil, err
+	}
+	if err := writeText(cw, "roots"); err != nil {
+		return nil, err
+	}
+	if err := cw.WriteMajorTypeHeader(cbg.MajArray, 1); err != nil {
+		return nil, err
+	}
+	if err := cbg.WriteCid(cw, root); err != nil {
+		return nil, err
+	}
+	if err := writeText(cw, "version"); err != nil {
+		return nil, err
+	}
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, 1); err != nil {
+		return nil, err
+	}
+	writeLd(buf, hdr.Bytes())
+
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for k := range keys {
+		blk, err := bs.Get(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		writeLd(buf, append(k.Bytes(), blk.RawData()...))
+	}
+	return buf.Bytes(), nil
+}
+
+func writeText(cw *cbg.CborWriter, s string) error {
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(cw, s)
+	return err
+}
+
+// writeLd writes a varint length prefixed section of a CAR file.
+func writeLd(w io.Writer, data []byte) {
+	var lenbuf [10]byte
+	n := 0
+	for x := uint64(len(data)); ; {
+		lenbuf[n] = byte(x & 0x7f)
+		x >>= 7
+		n++
+		if x == 0 {
+			break
+		}
+		lenbuf[n-1] |= 0x80
+	}
+	w.Write(lenbuf[:n])
+	w.Write(data)
+}
+
+func fakeToken(did, scope string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   did,
+		"scope": scope,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	s, err := token.SignedString(fakeJwtKey)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// fakePage returns the page of items requested by the cursor and limit query parameters.
+func fakePage(items []any, q url.Values) (page []any, cursor *string) {
+	start, _ := strconv.Atoi(q.Get("cursor"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 {
+		limit = 50
+	}
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + limit
+	if end >= len(items) {
+		end = len(items)
+	} else {
+		next := strconv.Itoa(end)
+		cursor = &next
+	}
+	page = items[start:end]
+	if page == nil {
+		page = []any{}
+	}
+	return page, cursor
+}
+
+func fakeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func fakeError(w http.ResponseWriter, code int, name, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": name, "message": msg})
+}
@@ -0,0 +1,26 @@
+// Command gen generates the CBOR encoding methods in ../cbor_gen.go.
+// Run it with go generate from the repo root.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// graphBlock must match the graphBlock record type in records.go.
+// We can't import that one, because it lives in package main.
+type graphBlock struct {
+	LexiconTypeID string `json:"$type,const=app.bsky.graph.block" cborgen:"$type,const=app.bsky.graph.block"`
+	CreatedAt     string `json:"createdAt" cborgen:"createdAt"`
+	Subject       string `json:"subject" cborgen:"subject"`
+}
+
+func main() {
+	err := cbg.WriteMapEncodersToFile("cbor_gen.go", "main", graphBlock{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -3,17 +3,25 @@ package main
 
 import (
 	"fmt"
-	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 
-	cliutil "github.com/bluesky-social/indigo/cmd/gosky/util"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slices"
 )
 
+const (
+	defaultPDS = "https://bsky.social"
+	defaultPLC = "https://plc.directory"
+)
+
+// pdsServer and plcServer are set by our --pds and --plc flags, or the
+// GOMODERATE_PDS and GOMODERATE_PLC environment variables.
 var (
-	pdsServer = "https://bsky.social"
-	plcServer = "https://plc.directory"
+	pdsServer = defaultPDS
+	plcServer = defaultPLC
 )
 
 // The normal way to specify our auth flags is as urfave/cli "global options", like:
@@ -26,6 +34,9 @@ var (
 //	gomoderate list mutes --my-user @me --app-key xyz
 var localUser, localAppKey, globalUser, globalAppKey string
 
+// Similarly, --dry-run is normally a global option, but we also accept it after a subcommand.
+var localDryRun, globalDryRun bool
+
 func main() {
 	// We have a separate goModerateMain to use with go-internal/testscripts.
 	os.Exit(goModerateMain())
@@ -64,7 +75,14 @@ func goModerateMain() int {
 		// TODO: consider something like: "gomoderate --my-user <@me> --app-key <key> mute <command>\n",
 		UsageText: "gomoderate list <command>\n" +
 			"gomoderate mute <command>\n" +
-			"gomoderate block <command>",
+			"gomoderate block <command>\n" +
+			"gomoderate unmute <command>\n" +
+			"gomoderate unblock <command>\n" +
+			"gomoderate apply <config.yaml>\n" +
+			"gomoderate undo [run-id]\n" +
+			"gomoderate untrust <user|file|url>\n" +
+			"gomoderate why <user>\n" +
+			"gomoderate cache clear",
 		Flags: []cli.Flag{ // these are considered 'global', and are specified before subcommands
 			&cli.StringFlag{
 				Name:        "my-user",
@@ -76,6 +94,57 @@ func goModerateMain() int {
 				Usage:       "An application `key` you created in the Bluesky (e.g., xj5s-fqo6-rtlm-lsrt)",
 				Destination: &globalAppKey,
 			},
+			&cli.BoolFlag{
+				Name:        "dry-run",
+				Usage:       "report what would be muted, blocked, unmuted or unblocked, without changing anything",
+				Destination: &globalDryRun,
+			},
+			&cli.IntFlag{
+				Name:        "concurrency",
+				Usage:       "how many users' DID documents to fetch at once",
+				Value:       resolveConcurrency,
+				Destination: &resolveConcurrency,
+			},
+			&cli.Float64Flag{
+				Name:        "rate",
+				Usage:       "the most PLC directory requests to make per second, or 0 for no limit",
+				Value:       resolveRate,
+				Destination: &resolveRate,
+			},
+			&cli.DurationFlag{
+				Name:        "cache-ttl",
+				Usage:       "how long to remember the handles and DIDs of users we look up, or 0 to not remember them",
+				Value:       cacheTTL,
+				Destination: &cacheTTL,
+			},
+			&cli.StringFlag{
+				Name:        "pds",
+				Usage:       "the `URL` of the PDS (personal data server) that hosts your account",
+				EnvVars:     []string{"GOMODERATE_PDS"},
+				Value:       pdsServer,
+				Destination: &pdsServer,
+			},
+			&cli.StringFlag{
+				Name:        "plc",
+				Usage:       "the `URL` of the PLC directory, for looking up did:plc DIDs",
+				EnvVars:     []string{"GOMODERATE_PLC"},
+				Value:       plcServer,
+				Destination: &plcServer,
+			},
+			&cli.StringFlag{
+				Name:        "state",
+				Usage:       "the `file` for gomoderate's local record of what it has changed (default: in your user config directory)",
+				Destination: &stateFile,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			var err error
+			pdsServer, err = serverURL("--pds", pdsServer, defaultPDS)
+			if err != nil {
+				return err
+			}
+			plcServer, err = serverURL("--plc", plcServer, defaultPLC)
+			return err
 		},
 		CommandNotFound: func(c *cli.Context, command string) {
 			// TODO: something similar for bad flags? maybe OnUsageError or InvalidFlagAccessHandler?
@@ -88,212 +157,201 @@ func goModerateMain() int {
 		},
 		// HideHelpCommand: true, // TODO: better? worse?
 		Commands: []*cli.Command{
+			moderationCommand(muting, localAuthFlags, listFlags),
+			moderationCommand(blocking, localAuthFlags, listFlags),
+			moderationCommand(unmuting, localAuthFlags, listFlags),
+			moderationCommand(unblocking, localAuthFlags, listFlags),
 			{
-				Name:  "mute",
-				Usage: "Mute users.",
-				// | from-url | from-file
-				UsageText: "gomoderate mute users <@user1> [@user2 ...]\n" +
-					"gomoderate mute from-user-blocks @user1 [@user2 ...]\n" +
-					"gomoderate mute from-file <file1> [file2 ...]\n" +
-					"gomoderate mute from-url <url1> [url2 ...]",
-				HideHelpCommand: true,
-				Subcommands: []*cli.Command{
-					{
-						Name:      "users",
-						Usage:     "Mute one or more specified users.",
-						UsageText: "gomoderate mute users <@user1> [@user2 ...]",
-						ArgsUsage: "<@user1> [@user2 ...]",
-						// must be authenticated
-						Flags: append(localAuthFlags, listFlags...),
-						Action: func(c *cli.Context) error {
-							examples := []string{"gomoderate --my-user @me.bsky.social --app-key xyz mute users @someone.bsky.social",
-								"gomoderate --my-user @me.bsky.social --app-key xyz mute users @someone.bsky.social @another.user.io"}
-							if c.Args().Len() < 1 {
-								return fatalArgs2(c, "at least one user must be provided", examples)
-							}
-							xrpcc, err := newXrpcClient()
-							if err != nil {
-								return err
-							}
-							err = authenticate(xrpcc)
-							if err != nil {
-								return err
-							}
-
-							err = doMuteCmd(c, xrpcc, c.Args().Slice())
-							if err != nil {
-								return err
-							}
-							return nil
-						},
+				Name:  "undo",
+				Usage: "Undo the changes made by a previous run of gomoderate.",
+				UsageText: "gomoderate undo [run-id]\n" +
+					"gomoderate undo --list",
+				ArgsUsage: "[run-id]",
+				// must be authenticated
+				Flags: append(slices.Clone(localAuthFlags),
+					&cli.BoolFlag{
+						Name:  "list",
+						Usage: "list recent runs rather than undoing one",
 					},
-					{
-						Name:      "from-user-blocks",
-						Usage:     "Mute users from user blocks.",
-						UsageText: "gomoderate mute from-user-blocks @user1 [@user2 ...]",
-						ArgsUsage: "user1 [@user2 ...]",
-						// must be authenticated
-						Flags: localAuthFlags,
-						Action: func(c *cli.Context) error {
-							if c.Args().Len() < 1 {
-								return fatalArgs(c, "at least one user must be provided")
-							}
-							xrpcc, err := newXrpcClient()
-							if err != nil {
-								return err
-							}
-							err = authenticate(xrpcc)
-							if err != nil {
-								return err
-							}
-
-							err = doMuteFromUserBlocksCmd(c, xrpcc, c.Args().Slice())
-							if err != nil {
-								return err
-							}
-							return nil
-						},
+					&cli.BoolFlag{
+						Name:  "yes",
+						Usage: "undo without asking for confirmation before re-blocking users",
 					},
-					{
-						Name:      "from-file",
-						Usage:     "Mute users from file.",
-						UsageText: "gomoderate mute from-file <file1> [file2 ...]",
-						ArgsUsage: "<file1> [file2 ...]",
-						Action: func(c *cli.Context) error {
-							if c.Args().Len() < 1 {
-								return fatalArgs(c, "at least one file must be provided")
-							}
-							xrpcc, err := newXrpcClient()
-							if err != nil {
-								return err
-							}
-							err = authenticate(xrpcc)
-							if err != nil {
-								return err
-							}
+					&cli.BoolFlag{
+						Name:        "dry-run",
+						Usage:       "report what would be undone, without changing anything",
+						Hidden:      true,
+						Destination: &localDryRun,
+					}),
+				Action: func(c *cli.Context) error {
+					examples := []string{"gomoderate --my-user @me.bsky.social --app-key xyz undo",
+						"gomoderate --my-user @me.bsky.social --app-key xyz undo 12",
+						"gomoderate --my-user @me.bsky.social --app-key xyz undo --list"}
+					if c.Args().Len() > 1 {
+						return fatalArgs2(c, "at most one run ID can be provided", examples)
+					}
+					var runID int64
+					if c.Args().Len() == 1 {
+						var err error
+						runID, err = strconv.ParseInt(c.Args().First(), 10, 64)
+						if err != nil || runID <= 0 {
+							return fatalArgs2(c, fmt.Sprintf("invalid run ID %q", c.Args().First()), examples)
+						}
+					}
+					xrpcc, err := newXrpcClient()
+					if err != nil {
+						return err
+					}
+					err = authenticate(xrpcc)
+					if err != nil {
+						return err
+					}
+					st, err := openStore()
+					if err != nil {
+						return err
+					}
+					defer st.Close()
 
-							filenames := c.Args().Slice()
-							for _, filename := range filenames {
-								f, err := os.Open(filename)
-								if err != nil {
-									return fmt.Errorf("mute from file: %w", err)
-								}
-								defer f.Close()
-
-								dids, err := parseUserList(f)
-								if err != nil {
-									return fmt.Errorf("parsing %s: %w", filename, err)
-								}
-								err = muteUsers(xrpcc, dids)
-								if err != nil {
-									return fmt.Errorf("handling %s: %w", filename, err)
-								}
-							}
-							return nil
-						},
+					if c.Bool("list") {
+						return doListRunsCmd(c, xrpcc, st)
+					}
+					err = doUndoCmd(c, xrpcc, st, runID)
+					if err != nil {
+						return err
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "apply",
+				Usage:     "Mute and block users as declared by a config file of trusted users, files, and URLs.",
+				UsageText: "gomoderate apply <config.yaml>",
+				ArgsUsage: "<config.yaml>",
+				// must be authenticated
+				Flags: append(slices.Clone(localAuthFlags),
+					&cli.BoolFlag{
+						Name:  "yes",
+						Usage: "mute or block users without asking for confirmation, even above --max",
 					},
-					{
-						Name:      "from-url",
-						Usage:     "Mute users from URL.",
-						UsageText: "gomoderate mute from-url <url1> [url2 ...]",
-						ArgsUsage: "<url1> [url2 ...]",
-						Action: func(c *cli.Context) error {
-							if c.Args().Len() < 1 {
-								return fatalArgs(c, "at least one URL must be provided")
-							}
-							xrpcc, err := newXrpcClient()
-							if err != nil {
-								return err
-							}
-							err = authenticate(xrpcc)
-							if err != nil {
-								return err
-							}
-
-							urls := c.Args().Slice()
-							client := cliutil.NewHttpClient()
-							for _, url := range urls {
-								resp, err := client.Get(url)
-								if err != nil {
-									return fmt.Errorf("failed fetching url: %w", err)
-								}
-								defer resp.Body.Close()
-								switch {
-								case resp.StatusCode == http.StatusNotFound:
-									return fmt.Errorf("resource not found: %s", url)
-								case resp.StatusCode != http.StatusOK:
-									return fmt.Errorf("unexpected status code %d when fetching %s", resp.StatusCode, url)
-								}
-								dids, err := parseUserList(resp.Body)
-								if err != nil {
-									return fmt.Errorf("parsing %s: %w", url, err)
-								}
-								err = muteUsers(xrpcc, dids)
-								if err != nil {
-									return fmt.Errorf("handling %s: %w", url, err)
-								}
-							}
-							return nil
-						},
+					maxFlag("mute or block"),
+					&cli.BoolFlag{
+						Name:  "include-follows",
+						Usage: "also mute or block users you follow, who are otherwise spared",
 					},
+					&cli.BoolFlag{
+						Name:  "sync",
+						Usage: "also unmute or unblock users that gomoderate muted or blocked because of a source that no longer lists them",
+					},
+					&cli.BoolFlag{
+						Name:        "dry-run",
+						Usage:       "report what would be muted or blocked, without changing anything",
+						Hidden:      true,
+						Destination: &localDryRun,
+					}),
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() != 1 {
+						return fatalArgs2(c, "exactly one config file must be provided",
+							[]string{"gomoderate --my-user @me.bsky.social --app-key xyz apply gomoderate.yaml"})
+					}
+					xrpcc, err := newXrpcClient()
+					if err != nil {
+						return err
+					}
+					err = authenticate(xrpcc)
+					if err != nil {
+						return err
+					}
+					st, err := openStore()
+					if err != nil {
+						return err
+					}
+					defer st.Close()
+
+					err = doApplyCmd(c, xrpcc, st, c.Args().First())
+					if err != nil {
+						return err
+					}
+					printUndoHint(st)
+					return nil
+				},
+			},
+			{
+				Name:  "untrust",
+				Usage: "Stop trusting a user, file, or URL, and unmute or unblock the users muted or blocked only because of it.",
+				UsageText: "gomoderate untrust <user>\n" +
+					"gomoderate untrust <file>\n" +
+					"gomoderate untrust <url>",
+				ArgsUsage: "<user|file|url>",
+				// must be authenticated
+				Flags: append(slices.Clone(localAuthFlags),
+					&cli.BoolFlag{
+						Name:        "dry-run",
+						Usage:       "report what would be unmuted or unblocked, without changing anything",
+						Hidden:      true,
+						Destination: &localDryRun,
+					}),
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() != 1 {
+						return fatalArgs2(c, "exactly one user, file, or URL must be provided",
+							[]string{"gomoderate --my-user @me.bsky.social --app-key xyz untrust @user1.bsky.social",
+								"gomoderate --my-user @me.bsky.social --app-key xyz untrust users.txt",
+								"gomoderate --my-user @me.bsky.social --app-key xyz untrust https://example.com/users.txt"})
+					}
+					xrpcc, err := newXrpcClient()
+					if err != nil {
+						return err
+					}
+					err = authenticate(xrpcc)
+					if err != nil {
+						return err
+					}
+					st, err := openStore()
+					if err != nil {
+						return err
+					}
+					defer st.Close()
+
+					err = doUntrustCmd(c, xrpcc, st, c.Args().First())
+					if err != nil {
+						return err
+					}
+					printUndoHint(st)
+					return nil
+				},
+			},
+			{
+				Name:      "why",
+				Usage:     "Explain why a user is muted or blocked by your account.",
+				UsageText: "gomoderate why <user>",
+				ArgsUsage: "<user>",
+				// must be authenticated
+				Flags: slices.Clone(localAuthFlags),
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() != 1 {
+						return fatalArgs2(c, "exactly one user must be provided",
+							[]string{"gomoderate --my-user @me.bsky.social --app-key xyz why @user1.bsky.social"})
+					}
+					xrpcc, err := newXrpcClient()
+					if err != nil {
+						return err
+					}
+					err = authenticate(xrpcc)
+					if err != nil {
+						return err
+					}
+					st, err := openStore()
+					if err != nil {
+						return err
+					}
+					defer st.Close()
+
+					err = doWhyCmd(c, xrpcc, st, c.Args().First())
+					if err != nil {
+						return err
+					}
+					return nil
 				},
 			},
-			// TODO: NYI
-			// {
-			// 	Name:            "block",
-			// 	Usage:           "Block users.",
-			// 	HideHelpCommand: true,
-			// 	Subcommands: []*cli.Command{
-			// 		{
-			// 			Name:      "users",
-			// 			Usage:     "Block specified users.",
-			// 			UsageText: "gomoderate block users <@user1> [@user2 ...]",
-			// 			ArgsUsage: "<@user1> [@user2 ...]",
-			// 			Action: func(c *cli.Context) error {
-			// 				if c.Args().Len() < 1 {
-			// 					return fatalArgs(c, "at least one user must be provided")
-			// 				}
-			// 				return fatalArgs(c, "sorry, not yet implemented. coming soon.")
-			// 			},
-			// 		},
-			// 		{
-			// 			Name:      "from-userblocks",
-			// 			Usage:     "Block users from userblocks.",
-			// 			UsageText: "gomoderate block from-userblocks @user1 [@user2 ...]",
-			// 			ArgsUsage: "user1 [@user2 ...]",
-			// 			Action: func(c *cli.Context) error {
-			// 				if c.Args().Len() < 1 {
-			// 					return fatalArgs(c, "at least one user must be provided")
-			// 				}
-			// 				return fatalArgs(c, "sorry, not yet implemented. coming soon.")
-			// 			},
-			// 		},
-			// 		{
-			// 			Name:      "from-file",
-			// 			Usage:     "Block users from file.",
-			// 			UsageText: "gomoderate block from-file <file1> [file2 ...]",
-			// 			ArgsUsage: "<file1> [file2 ...]",
-			// 			Action: func(c *cli.Context) error {
-			// 				if c.Args().Len() < 1 {
-			// 					return fatalArgs(c, "at least one file must be provided")
-			// 				}
-			// 				return fatalArgs(c, "sorry, not yet implemented. coming soon.")
-			// 			},
-			// 		},
-			// 		{
-			// 			Name:      "from-url",
-			// 			Usage:     "Block users from URL.",
-			// 			UsageText: "gomoderate block from-url <url1> [url2 ...]",
-			// 			ArgsUsage: "<url1> [url2 ...]",
-			// 			Action: func(c *cli.Context) error {
-			// 				if c.Args().Len() < 1 {
-			// 					return fatalArgs(c, "at least one URL must be provided")
-			// 				}
-			// 				return fatalArgs(c, "sorry, not yet implemented. coming soon.")
-			// 			},
-			// 		},
-			// 	},
-			// },
 			{
 				Name:            "list",
 				Usage:           "List mutes or blocks.",
@@ -348,6 +406,23 @@ func goModerateMain() int {
 					},
 				},
 			},
+			{
+				Name:            "cache",
+				Usage:           "Manage the cache of users' handles and DIDs.",
+				HideHelpCommand: true,
+				Subcommands: []*cli.Command{
+					{
+						Name:  "clear",
+						Usage: "Forget the handles and DIDs of users looked up by earlier runs.",
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() > 0 {
+								return fatalArgs(c, "cache clear command does not accept any arguments")
+							}
+							return doCacheClearCmd(c)
+						},
+					},
+				},
+			},
 		},
 	}
 
@@ -361,6 +436,237 @@ func goModerateMain() int {
 	return 0
 }
 
+// moderationCommand returns the command for one way of moderating users,
+// such as "mute", along with its users, from-user-blocks, from-file, and from-url subcommands.
+func moderationCommand(m moderation, localAuthFlags, listFlags []cli.Flag) *cli.Command {
+	title := strings.ToUpper(m.verb[:1]) + m.verb[1:]
+
+	dryRunFlag := &cli.BoolFlag{
+		Name:        "dry-run",
+		Usage:       "report what would be " + m.past + ", without changing anything",
+		Hidden:      true,
+		Destination: &localDryRun,
+	}
+
+	// Flags for the from-user-blocks, from-file, and from-url subcommands.
+	var bulkFlags []cli.Flag
+	bulkFlags = append(bulkFlags, localAuthFlags...)
+	bulkFlags = append(bulkFlags, dryRunFlag)
+	if !m.undo {
+		bulkFlags = append(bulkFlags, &cli.BoolFlag{
+			Name:  "yes",
+			Usage: fmt.Sprintf("%s without asking for confirmation, even above --max", m.verb),
+		})
+		bulkFlags = append(bulkFlags, maxFlag(m.verb))
+		bulkFlags = append(bulkFlags, &cli.StringFlag{
+			Name:  "allowlist",
+			Usage: fmt.Sprintf("never %s the users listed in this `file`, which has the same format as from-file", m.verb),
+		})
+		bulkFlags = append(bulkFlags, &cli.BoolFlag{
+			Name:  "include-follows",
+			Usage: fmt.Sprintf("also %s users you follow, who are otherwise spared", m.verb),
+		})
+		bulkFlags = append(bulkFlags, &cli.BoolFlag{
+			Name:  "sync",
+			Usage: fmt.Sprintf("also un%s users that gomoderate %s because of a source that no longer lists them", m.verb, m.past),
+		})
+	}
+
+	// A quorum of trusted users can be required before we act on their blocks.
+	userBlocksFlags := slices.Clone(bulkFlags)
+	if !m.undo {
+		userBlocksFlags = append(userBlocksFlags,
+			&cli.IntFlag{
+				Name:  "min-sources",
+				Usage: fmt.Sprintf("only %s users blocked by at least `N` of the supplied users", m.verb),
+				Value: 1,
+			},
+			&cli.Float64Flag{
+				Name:  "min-fraction",
+				Usage: fmt.Sprintf("only %s users blocked by at least this `fraction` of the supplied users, such as 0.5", m.verb),
+			})
+	}
+
+	return &cli.Command{
+		Name:  m.verb,
+		Usage: title + " users.",
+		UsageText: "gomoderate " + m.verb + " users <@user1> [@user2 ...]\n" +
+			"gomoderate " + m.verb + " from-user-blocks @user1 [@user2 ...]\n" +
+			"gomoderate " + m.verb + " from-file <file1> [file2 ...]\n" +
+			"gomoderate " + m.verb + " from-url <url1> [url2 ...]",
+		HideHelpCommand: true,
+		Subcommands: []*cli.Command{
+			{
+				Name:      "users",
+				Usage:     title + " one or more specified users.",
+				UsageText: "gomoderate " + m.verb + " users <@user1> [@user2 ...]",
+				ArgsUsage: "<@user1> [@user2 ...]",
+				// must be authenticated
+				Flags: append(append(slices.Clone(localAuthFlags), listFlags...), dryRunFlag),
+				Action: func(c *cli.Context) error {
+					examples := []string{"gomoderate --my-user @me.bsky.social --app-key xyz " + m.verb + " users @someone.bsky.social",
+						"gomoderate --my-user @me.bsky.social --app-key xyz " + m.verb + " users @someone.bsky.social @another.user.io"}
+					if c.Args().Len() < 1 {
+						return fatalArgs2(c, "at least one user must be provided", examples)
+					}
+					xrpcc, err := newXrpcClient()
+					if err != nil {
+						return err
+					}
+					err = authenticate(xrpcc)
+					if err != nil {
+						return err
+					}
+					st, err := openStore()
+					if err != nil {
+						return err
+					}
+					defer st.Close()
+
+					err = doUsersCmd(c, xrpcc, st, m, c.Args().Slice())
+					if err != nil {
+						return err
+					}
+					printUndoHint(st)
+					return nil
+				},
+			},
+			{
+				Name:      "from-user-blocks",
+				Usage:     title + " users from user blocks.",
+				UsageText: "gomoderate " + m.verb + " from-user-blocks @user1 [@user2 ...]",
+				ArgsUsage: "user1 [@user2 ...]",
+				// must be authenticated
+				Flags: userBlocksFlags,
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() < 1 {
+						return fatalArgs(c, "at least one user must be provided")
+					}
+					// unmute and unblock have no quorum flags.
+					if !m.undo && c.Int("min-sources") < 1 {
+						return fatalArgs(c, "--min-sources must be at least 1")
+					}
+					if f := c.Float64("min-fraction"); !m.undo && (f < 0 || f > 1) {
+						return fatalArgs(c, "--min-fraction must be between 0 and 1")
+					}
+					xrpcc, err := newXrpcClient()
+					if err != nil {
+						return err
+					}
+					err = authenticate(xrpcc)
+					if err != nil {
+						return err
+					}
+					st, err := openStore()
+					if err != nil {
+						return err
+					}
+					defer st.Close()
+
+					err = doFromUserBlocksCmd(c, xrpcc, st, m, c.Args().Slice())
+					if err != nil {
+						return err
+					}
+					printUndoHint(st)
+					return nil
+				},
+			},
+			{
+				Name:      "from-file",
+				Usage:     title + " users from file.",
+				UsageText: "gomoderate " + m.verb + " from-file <file1> [file2 ...]",
+				ArgsUsage: "<file1> [file2 ...]",
+				Flags:     bulkFlags,
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() < 1 {
+						return fatalArgs(c, "at least one file must be provided")
+					}
+					xrpcc, err := newXrpcClient()
+					if err != nil {
+						return err
+					}
+					err = authenticate(xrpcc)
+					if err != nil {
+						return err
+					}
+					st, err := openStore()
+					if err != nil {
+						return err
+					}
+					defer st.Close()
+
+					err = doFromFileCmd(c, xrpcc, st, m, c.Args().Slice())
+					if err != nil {
+						return err
+					}
+					printUndoHint(st)
+					return nil
+				},
+			},
+			{
+				Name:      "from-url",
+				Usage:     title + " users from URL.",
+				UsageText: "gomoderate " + m.verb + " from-url <url1> [url2 ...]",
+				ArgsUsage: "<url1> [url2 ...]",
+				Flags:     bulkFlags,
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() < 1 {
+						return fatalArgs(c, "at least one URL must be provided")
+					}
+					xrpcc, err := newXrpcClient()
+					if err != nil {
+						return err
+					}
+					err = authenticate(xrpcc)
+					if err != nil {
+						return err
+					}
+					st, err := openStore()
+					if err != nil {
+						return err
+					}
+					defer st.Close()
+
+					err = doFromURLCmd(c, xrpcc, st, m, c.Args().Slice())
+					if err != nil {
+						return err
+					}
+					printUndoHint(st)
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// maxFlag returns our --max flag, which caps how many users a bulk command mutes or blocks
+// without confirmation. verb says what the command does, such as "mute".
+func maxFlag(verb string) cli.Flag {
+	return &cli.IntFlag{
+		Name:  "max",
+		Usage: fmt.Sprintf("refuse to %s more than `N` users in one run without confirmation", verb),
+		Value: defaultMax,
+	}
+}
+
+// serverURL checks that s, from the flag named flag, is the URL of a server,
+// and returns it without any trailing slash. An empty s, as from an environment
+// variable set to "", means the default server def.
+func serverURL(flag, s, def string) (string, error) {
+	if s == "" {
+		return def, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("%s must be an http or https URL, such as https://example.com, not %q", flag, s)
+	}
+	return strings.TrimSuffix(s, "/"), nil
+}
+
+func dryRun() bool {
+	return localDryRun || globalDryRun
+}
+
 func authFlags() (user string, appKey string, err error) {
 	haveUser := localUser != "" || globalUser != ""
 	haveAppKey := localAppKey != "" || globalAppKey != ""
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/mattn/go-isatty"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slices"
+)
+
+// A moderation is one way of moderating users, such as muting or blocking,
+// or undoing a mute or block.
+type moderation struct {
+	verb   string // e.g., "mute"
+	gerund string // e.g., "muting"
+	past   string // e.g., "muted"
+	state  string // the state we add or remove, e.g., "muted" for both mute and unmute
+	undo   bool   // whether we remove the state, as with unmute and unblock
+	public bool   // whether anyone in the world can see the results, as with blocks
+
+	// current returns the users currently in our state (e.g., muted), keyed by DID.
+	// For blocks, the values are the rkeys of the block records.
+	current func(xrpcc *xrpc.Client) (map[string][]string, error)
+	// change mutes, blocks, unmutes, or unblocks a single user.
+	change func(xrpcc *xrpc.Client, did string, rkeys []string) error
+}
+
+// defaultMax is how many users a bulk command mutes or blocks in one run without confirmation.
+const defaultMax = 250
+
+var (
+	muting   = moderation{verb: "mute", gerund: "muting", past: "muted", state: "muted", current: currentMutes, change: muteUser}
+	blocking = moderation{verb: "block", gerund: "blocking", past: "blocked", state: "blocked", public: true, current: currentBlocks, change: blockUser}
+
+	unmuting   = moderation{verb: "unmute", gerund: "unmuting", past: "unmuted", state: "muted", undo: true, current: currentMutes, change: unmuteUser}
+	unblocking = moderation{verb: "unblock", gerund: "unblocking", past: "unblocked", state: "blocked", undo: true, current: currentBlocks, change: unblockUser}
+)
+
+// moderationFor returns the moderation for a verb, such as muting for "mute".
+func moderationFor(verb string) (moderation, bool) {
+	for _, m := range []moderation{muting, blocking, unmuting, unblocking} {
+		if m.verb == verb {
+			return m, true
+		}
+	}
+	return moderation{}, false
+}
+
+// undoFor returns the moderation that undoes an action, such as unmuting for a mute.
+func undoFor(action string) (moderation, error) {
+	switch action {
+	case muting.verb:
+		return unmuting, nil
+	case blocking.verb:
+		return unblocking, nil
+	case unmuting.verb:
+		return muting, nil
+	case unblocking.verb:
+		return blocking, nil
+	}
+	return moderation{}, fmt.Errorf("unknown action %q", action)
+}
+
+// opposite returns the moderation that reverses m, such as unmuting for muting.
+func (m moderation) opposite() moderation {
+	undo, err := undoFor(m.verb)
+	if err != nil {
+		panic(err) // our moderations all have opposites
+	}
+	return undo
+}
+
+// skipped describes users we skip because they are already where we want them,
+// such as "already muted" when muting, or "not muted" when unmuting.
+func (m moderation) skipped() string {
+	if m.undo {
+		return "not " + m.state
+	}
+	return "already " + m.state
+}
+
+// A source is the reason we were asked to moderate a user.
+type source struct {
+	kind   string // one of the source kinds below
+	name   string // a trusted user's DID, a file's absolute path, or a URL
+	handle string // for user blocks, the trusted user's handle, if known
+}
+
+const (
+	sourceCommandLine = "command-line"
+	sourceUserBlocks  = "user-blocks"
+	sourceFile        = "file"
+	sourceURL         = "url"
+	sourceUndo        = "undo"    // the name is the run being undone
+	sourceUntrust     = "untrust" // the name and handle are those of the source we stopped trusting
+	sourceDropped     = "dropped" // the name and handle are those of the source that no longer lists the user
+)
+
+var commandLineSource = source{kind: sourceCommandLine}
+
+func (s source) String() string {
+	switch s.kind {
+	case sourceCommandLine:
+		return "command line"
+	case sourceUndo:
+		return "undo of run " + s.name
+	case sourceUntrust:
+		if s.handle == "" {
+			return "untrusting " + s.name
+		}
+		return "untrusting @" + s.handle
+	case sourceDropped:
+		if s.handle == "" {
+			return "no longer listed by " + s.name
+		}
+		return "no longer listed by @" + s.handle
+	case sourceUserBlocks:
+		if s.handle == "" {
+			return "blocks by " + s.name
+		}
+		return "blocks by @" + s.handle
+	default:
+		return s.kind + " " + s.name
+	}
+}
+
+// A target is a user we were asked to moderate, along with the sources that asked.
+type target struct {
+	resolvedUser
+	sources []source
+}
+
+// targetList is an ordered list of targets without duplicate DIDs.
+type targetList struct {
+	targets []*target
+	byDid   map[string]*target
+	fetched []source // the sources we fetched, including any that listed no users
+}
+
+// add adds u to the list because of src, merging with any existing target for the same DID.
+func (tl *targetList) add(u resolvedUser, src source) {
+	if tl.byDid == nil {
+		tl.byDid = make(map[string]*target)
+	}
+	t, ok := tl.byDid[u.did]
+	if !ok {
+		t = &target{resolvedUser: u}
+		tl.byDid[u.did] = t
+		tl.targets = append(tl.targets, t)
+	}
+	if t.knownHandle() == "" && (u.knownHandle() != "" || t.handle == "") {
+		t.handle = u.handle
+		t.status = u.status
+	}
+	if !slices.Contains(t.sources, src) {
+		t.sources = append(t.sources, src)
+	}
+}
+
+// lists reports whether src asks for the user with did.
+func (tl *targetList) lists(did string, src source) bool {
+	t, ok := tl.byDid[did]
+	if !ok {
+		return false
+	}
+	for _, s := range t.sources {
+		if s.kind == src.kind && s.name == src.name {
+			return true
+		}
+	}
+	return false
+}
+
+// scored returns the targets whose sources' weights add up to at least min, along with those that do not.
+func (tl *targetList) scored(min float64, weight func(source) float64) (kept targetList, below []*target) {
+	kept.fetched = tl.fetched
+	for _, t := range tl.targets {
+		var score float64
+		for _, src := range t.sources {
+			score += weight(src)
+		}
+		if score < min {
+			below = append(below, t)
+			continue
+		}
+		for _, src := range t.sources {
+			kept.add(t.resolvedUser, src)
+		}
+	}
+	return kept, below
+}
+
+// without returns the targets whose DIDs are not in dids, along with those that are.
+func (tl *targetList) without(dids map[string]bool) (kept targetList, removed []*target) {
+	kept.fetched = tl.fetched
+	for _, t := range tl.targets {
+		if dids[t.did] {
+			removed = append(removed, t)
+			continue
+		}
+		for _, src := range t.sources {
+			kept.add(t.resolvedUser, src)
+		}
+	}
+	return kept, removed
+}
+
+// moderate mutes, blocks, unmutes, or unblocks the targets, depending on m,
+// skipping any targets that are already where we want them.
+// bulk reports whether the targets came from a bulk source, such as a file or other users' blocks.
+// With --dry-run, we stop before changing anything and instead report what we would do.
+// Each change is recorded in our state database, along with the reasons each user is muted or blocked.
+func moderate(c *cli.Context, xrpcc *xrpc.Client, st *store, m moderation, targets targetList, bulk bool) error {
+	current, err := m.current(xrpcc)
+	if err != nil {
+		return fmt.Errorf("check for %s users: %w", m.state, err)
+	}
+
+	var toChange, skipped []*target
+	for _, t := range targets.targets {
+		_, ok := current[t.did]
+		if ok == m.undo {
+			toChange = append(toChange, t)
+		} else {
+			skipped = append(skipped, t)
+		}
+	}
+	total := len(targets.targets)
+
+	if !dryRun() && !m.undo {
+		// These users are already muted or blocked, but note that these sources also ask for it.
+		for _, t := range skipped {
+			err := st.noteReasons(xrpcc.Auth.Did, m.verb, t)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if dryRun() {
+		fmt.Printf("\nwould %s %d of %d users:\n", m.verb, len(toChange), total)
+		printTargets(toChange)
+		fmt.Printf("\n%d of %d users %s:\n", len(skipped), total, m.skipped())
+		printTargets(skipped)
+		fmt.Printf("\ndry run, no users %s\n", m.past)
+		return nil
+	}
+
+	switch {
+	case len(toChange) == 0 && m.undo:
+		fmt.Printf("none of %d users are %s, nothing more to do\n", total, m.state)
+		return nil
+	case len(toChange) == 0:
+		fmt.Printf("all %d users %s, nothing more to do\n", total, m.skipped())
+		return nil
+	case len(skipped) > 0:
+		fmt.Printf("%d of %d users %s\n", len(skipped), total, m.skipped())
+	}
+
+	// Blocks are public, so we ask before bulk blocking, unless --yes was supplied.
+	// We also ask before muting or blocking more than --max users, and refuse if we cannot ask.
+	// When undoing a run, we restore what was there before, so we do not limit it.
+	if bulk && !c.Bool("yes") {
+		max := c.Int("max")
+		if max <= 0 {
+			max = defaultMax
+		}
+		tty := isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())
+		over := !m.undo && st.undoes == 0 && len(toChange) > max
+		if over && !tty {
+			return cli.Exit(fmt.Sprintf("refusing to %s %d users, more than the maximum of %d per run. Use --max %d or --yes to %s them anyway.",
+				m.verb, len(toChange), max, len(toChange), m.verb), 1)
+		}
+		if m.public || over || (tty && !m.undo) {
+			if m.public {
+				fmt.Printf("\nWARNING: %ss are public. Anyone in the world can see who you have %s.\n", m.verb, m.past)
+			}
+			if over {
+				fmt.Printf("\nWARNING: that is more than the maximum of %d users per run.\n", max)
+			}
+			ok, err := confirm(fmt.Sprintf("%s %d of %d users?", m.verb, len(toChange), total))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return cli.Exit(fmt.Sprintf("canceled, no users %s", m.past), 1)
+			}
+		}
+	}
+
+	changed := 0
+	for _, t := range toChange {
+		err := m.change(xrpcc, t.did, current[t.did])
+		if err != nil && t.status != "" {
+			// The server may refuse to moderate an account that no longer exists,
+			// which shouldn't stop us moderating the rest.
+			fmt.Fprintf(os.Stderr, "warning: could not %s %s (%s): %v\n", m.verb, t.did, t.status, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to %s: %s: %w", m.verb, t.did, err)
+		}
+		err = st.record(action{command: c.Command.HelpName, account: xrpcc.Auth.Did, action: m.verb, target: t})
+		if err != nil {
+			return err
+		}
+		if m.undo {
+			err = st.dropReasons(xrpcc.Auth.Did, m.opposite().verb, t.did)
+		} else {
+			err = st.noteReasons(xrpcc.Auth.Did, m.verb, t)
+		}
+		if err != nil {
+			return err
+		}
+		changed++
+	}
+	fmt.Printf("successfully %s %d users\n", m.past, changed)
+	return nil
+}
+
+func printTargets(targets []*target) {
+	for _, t := range targets {
+		user := t.did
+		if t.handle != "" {
+			user += " @" + t.handle
+		}
+		if t.status != "" {
+			user += " (" + t.status + ")"
+		}
+		var from []string
+		for _, src := range t.sources {
+			from = append(from, src.String())
+		}
+		fmt.Printf("   %s  (from %s)\n", user, strings.Join(from, ", "))
+	}
+}
+
+func currentMutes(xrpcc *xrpc.Client) (map[string][]string, error) {
+	mutes, err := listMutes(xrpcc)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string][]string)
+	for _, u := range mutes {
+		current[u.did] = nil
+	}
+	return current, nil
+}
+
+func currentBlocks(xrpcc *xrpc.Client) (map[string][]string, error) {
+	blocks, err := listMyBlocks(xrpcc)
+	if err != nil {
+		return nil, err
+	}
+	// there might be more than one block record for a user.
+	current := make(map[string][]string)
+	for _, r := range blocks {
+		current[r.subject] = append(current[r.subject], r.rkey)
+	}
+	return current, nil
+}
+
+func muteUser(xrpcc *xrpc.Client, did string, _ []string) error {
+	return bsky.GraphMuteActor(context.TODO(),
+		xrpcc,
+		&bsky.GraphMuteActor_Input{Actor: did})
+}
+
+func unmuteUser(xrpcc *xrpc.Client, did string, _ []string) error {
+	return bsky.GraphUnmuteActor(context.TODO(),
+		xrpcc,
+		&bsky.GraphUnmuteActor_Input{Actor: did})
+}
+
+func blockUser(xrpcc *xrpc.Client, did string, _ []string) error {
+	_, err := comatproto.RepoCreateRecord(context.TODO(),
+		xrpcc,
+		&comatproto.RepoCreateRecord_Input{
+			Collection: blockCollection,
+			Repo:       xrpcc.Auth.Did,
+			Record: &lexutil.LexiconTypeDecoder{Val: &graphBlock{
+				Subject:   did,
+				CreatedAt: time.Now().UTC().Format(util.ISO8601),
+			}},
+		})
+	return err
+}
+
+func unblockUser(xrpcc *xrpc.Client, did string, rkeys []string) error {
+	for _, rkey := range rkeys {
+		err := comatproto.RepoDeleteRecord(context.TODO(),
+			xrpcc,
+			&comatproto.RepoDeleteRecord_Input{
+				Collection: blockCollection,
+				Repo:       xrpcc.Auth.Did,
+				Rkey:       rkey,
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// confirm asks a yes or no question on stdin. The default is no.
+func confirm(question string) (bool, error) {
+	fmt.Printf("%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err == io.EOF {
+		fmt.Println() // keep the output tidy when there is no input.
+	} else if err != nil {
+		return false, fmt.Errorf("confirm: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
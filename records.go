@@ -0,0 +1,26 @@
+package main
+
+import (
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+)
+
+// The version of indigo we use does not yet know about app.bsky.graph.block records,
+// so we define our own record type here, mirroring indigo's generated bsky.GraphFollow.
+// The CBOR encoding methods in cbor_gen.go are generated by github.com/whyrusleeping/cbor-gen,
+// via gen/main.go, which has its own copy of graphBlock to keep in sync with this one.
+
+//go:generate go run ./gen
+
+const blockCollection = "app.bsky.graph.block"
+
+func init() {
+	// Register so that indigo can decode block records into a graphBlock, such as when we create one.
+	lexutil.RegisterType(blockCollection, &graphBlock{})
+}
+
+// graphBlock is an app.bsky.graph.block record.
+type graphBlock struct {
+	LexiconTypeID string `json:"$type,const=app.bsky.graph.block" cborgen:"$type,const=app.bsky.graph.block"`
+	CreatedAt     string `json:"createdAt" cborgen:"createdAt"`
+	Subject       string `json:"subject" cborgen:"subject"`
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/rogpeppe/go-internal/testscript"
@@ -22,21 +25,49 @@ func TestScripts(t *testing.T) {
 		UpdateScripts: *updateFlag,
 		Setup: func(e *testscript.Env) error {
 			e.Vars = append(e.Vars, "GOMODERATE_TEST_APPKEY="+os.Getenv("GOMODERATE_TEST_APPKEY"))
+			// Testscripts default to HOME=/no-home. Instead, keep our local state
+			// (such as the state database in the user config dir and the resolve cache
+			// in the user cache dir) in the work dir.
+			e.Setenv(homeEnvVar(), e.WorkDir)
+			if runtime.GOOS == "windows" {
+				e.Setenv("AppData", filepath.Join(e.WorkDir, "AppData"))
+				e.Setenv("LocalAppData", filepath.Join(e.WorkDir, "LocalAppData"))
+			}
 			return nil
 		},
-		// future:
-		// Testscripts default to HOME=/no-home
-		// Setup: func(e *testscript.Env) error {
-		// 	home, err := os.UserHomeDir()
-		// 	if err != nil {
-		// 		log.Fatal(err)
-		// 	}
-		// 	return nil
-		// },
+		Cmds: map[string]func(ts *testscript.TestScript, neg bool, args []string){
+			"fakebsky": cmdFakeBluesky,
+		},
+		Condition: func(cond string) (bool, error) {
+			// [appkey] reports whether we have an app key for end-to-end tests against bsky.social.
+			if cond == "appkey" {
+				return os.Getenv("GOMODERATE_TEST_APPKEY") != "", nil
+			}
+			return false, fmt.Errorf("unknown condition %q", cond)
+		},
 	}
 	testscript.Run(t, p)
 }
 
+// cmdFakeBluesky starts a fake Bluesky server seeded from the fixture file named by its argument,
+// and points gomoderate at it for the rest of the script. The server appends each request
+// it handles to fakebsky.log in the work dir. $FAKEBSKY is its URL, and $FAKEWEBDID
+// is the did:web DID that stands in for did:web:fake in the fixture.
+//
+//	fakebsky world.txt
+func cmdFakeBluesky(ts *testscript.TestScript, neg bool, args []string) {
+	if neg || len(args) != 1 {
+		ts.Fatalf("usage: fakebsky fixture")
+	}
+	fb, err := newFakeBluesky(ts.ReadFile(args[0]), ts.MkAbs("fakebsky.log"))
+	ts.Check(err)
+	ts.Defer(fb.Close)
+	ts.Setenv("FAKEBSKY", fb.URL())
+	ts.Setenv("FAKEWEBDID", fb.webDid)
+	ts.Setenv("GOMODERATE_PDS", fb.URL())
+	ts.Setenv("GOMODERATE_PLC", fb.URL())
+}
+
 type goModerateTestingMain struct {
 	m *testing.M
 }
@@ -46,9 +77,9 @@ func (m goModerateTestingMain) Run() int {
 	return m.m.Run()
 }
 
-// func homeEnvVar() string {
-// 	if runtime.GOOS == "windows" {
-// 		return "USERPROFILE"
-// 	}
-// 	return "HOME"
-// }
+func homeEnvVar() string {
+	if runtime.GOOS == "windows" {
+		return "USERPROFILE"
+	}
+	return "HOME"
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// store is our local state database, which records every change gomoderate makes.
+// By default, it lives in the user's config directory, such as ~/.config/gomoderate/state.db.
+//
+// Each invocation of gomoderate that changes anything is a run, and the changes
+// are journaled as actions under that run so that they can be undone later.
+type store struct {
+	db     *sql.DB
+	run    int64 // the run for this invocation, created when we record our first action
+	undoes int64 // if this invocation is an undo, the run being undone
+}
+
+// migrations are applied in order to bring an older state database up to date.
+// The number of migrations already applied is tracked in sqlite's user_version.
+// Only append to this list.
+var migrations = []string{
+	`CREATE TABLE actions (
+		id      INTEGER PRIMARY KEY,
+		time    TEXT NOT NULL, -- RFC 3339, UTC
+		command TEXT NOT NULL, -- e.g., "gomoderate mute from-url"
+		account TEXT NOT NULL, -- the DID of our account that was changed
+		action  TEXT NOT NULL, -- mute, block, unmute, or unblock
+		did     TEXT NOT NULL, -- the DID of the user that was muted, etc.
+		handle  TEXT NOT NULL  -- the handle at the time, if known
+	);
+	CREATE INDEX actions_did ON actions (account, did);
+	CREATE TABLE action_sources (
+		action_id INTEGER NOT NULL REFERENCES actions (id),
+		kind      TEXT NOT NULL, -- command-line, user-blocks, file, or url
+		name      TEXT NOT NULL, -- a trusted user's DID, a file's absolute path, or a URL
+		handle    TEXT NOT NULL  -- for user-blocks, the trusted user's handle at the time
+	);
+	CREATE INDEX action_sources_action_id ON action_sources (action_id);`,
+
+	`CREATE TABLE runs (
+		id      INTEGER PRIMARY KEY,
+		time    TEXT NOT NULL,
+		command TEXT NOT NULL,
+		account TEXT NOT NULL,
+		undoes  INTEGER REFERENCES runs (id), -- for an undo, the run it undid
+		undone  TEXT                          -- when this run was undone, if it was
+	);
+	ALTER TABLE actions ADD COLUMN run_id INTEGER REFERENCES runs (id);
+	CREATE INDEX actions_run_id ON actions (run_id);`,
+
+	`CREATE TABLE reasons (
+		account    TEXT NOT NULL,
+		action     TEXT NOT NULL, -- mute or block
+		did        TEXT NOT NULL, -- the DID of the user that is muted or blocked
+		kind       TEXT NOT NULL, -- the source, as in action_sources
+		name       TEXT NOT NULL,
+		handle     TEXT NOT NULL,
+		first_seen TEXT NOT NULL, -- when we first saw this source ask for this user
+		last_seen  TEXT NOT NULL, -- when we most recently saw it
+		PRIMARY KEY (account, action, did, kind, name)
+	);
+	CREATE INDEX reasons_source ON reasons (account, kind, name);`,
+}
+
+// stateFile is set by our --state flag.
+var stateFile string
+
+// openStore opens our state database, creating it if needed.
+func openStore() (*store, error) {
+	path := stateFile
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("open state database: %w", err)
+		}
+		path = filepath.Join(dir, "gomoderate", "state.db")
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("open state database: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("open state database: %w", err)
+	}
+	st := &store{db: db}
+	err = st.migrate()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open state database %s: %w", path, err)
+	}
+	return st, nil
+}
+
+func (st *store) Close() error {
+	return st.db.Close()
+}
+
+func (st *store) migrate() error {
+	var version int
+	err := st.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("state database version %d is newer than this gomoderate supports (%d)", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := st.db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(migrations[i])
+		if err == nil {
+			// PRAGMA does not accept placeholders.
+			_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// An action is one change we made, such as muting a single user.
+type action struct {
+	command string // e.g., "gomoderate mute from-url"
+	account string // the DID of our account
+	action  string // mute, block, unmute, or unblock
+	target  *target
+}
+
+// record records a change we made, along with the sources that asked for it.
+func (st *store) record(a action) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if st.run == 0 {
+		var undoes any
+		if st.undoes != 0 {
+			undoes = st.undoes
+		}
+		res, err := st.db.Exec(`INSERT INTO runs (time, command, account, undoes) VALUES (?, ?, ?, ?)`,
+			now, a.command, a.account, undoes)
+		if err != nil {
+			return fmt.Errorf("record run: %w", err)
+		}
+		st.run, err = res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("record run: %w", err)
+		}
+	}
+
+	tx, err := st.db.Begin()
+	if err != nil {
+		return fmt.Errorf("record %s of %s: %w", a.action, a.target.did, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO actions (run_id, time, command, account, action, did, handle) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		st.run, now, a.command, a.account, a.action, a.target.did, a.target.knownHandle())
+	if err != nil {
+		return fmt.Errorf("record %s of %s: %w", a.action, a.target.did, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("record %s of %s: %w", a.action, a.target.did, err)
+	}
+	for _, src := range a.target.sources {
+		_, err := tx.Exec(`INSERT INTO action_sources (action_id, kind, name, handle) VALUES (?, ?, ?, ?)`,
+			id, src.kind, src.name, src.handle)
+		if err != nil {
+			return fmt.Errorf("record %s of %s: %w", a.action, a.target.did, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// noteReasons records that t's sources ask for it to be muted or blocked by our account.
+// Unlike the actions journal, this includes sources that ask for users who were already muted or blocked,
+// so that we know every reason a user is muted or blocked, not just the first.
+func (st *store) noteReasons(account, action string, t *target) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, src := range t.sources {
+		_, err := st.db.Exec(`INSERT INTO reasons (account, action, did, kind, name, handle, first_seen, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (account, action, did, kind, name) DO UPDATE SET handle = excluded.handle, last_seen = excluded.last_seen`,
+			account, action, t.did, src.kind, src.name, src.handle, now, now)
+		if err != nil {
+			return fmt.Errorf("record reasons to %s %s: %w", action, t.did, err)
+		}
+	}
+	return nil
+}
+
+// dropReasons forgets why a user was muted or blocked, such as after we unmute or unblock them.
+func (st *store) dropReasons(account, action, did string) error {
+	_, err := st.db.Exec(`DELETE FROM reasons WHERE account = ? AND action = ? AND did = ?`, account, action, did)
+	if err != nil {
+		return fmt.Errorf("forget reasons to %s %s: %w", action, did, err)
+	}
+	return nil
+}
+
+// A sourcedUser is a user that a source asks our account to mute or block.
+type sourcedUser struct {
+	action string // mute or block
+	user   resolvedUser
+	others int  // how many other sources also ask for this
+	ours   bool // whether gomoderate made the current mute or block, rather than the Bluesky app, say
+}
+
+// sourcedUsers returns the users that a source asks our account to mute or block.
+func (st *store) sourcedUsers(account string, src source) ([]sourcedUser, error) {
+	rows, err := st.db.Query(`
+		SELECT r.action, r.did,
+			IFNULL((SELECT a.handle FROM actions a
+				WHERE a.account = r.account AND a.did = r.did AND a.handle != ''
+				ORDER BY a.id DESC LIMIT 1), ''),
+			(SELECT COUNT(*) FROM reasons o
+				WHERE o.account = r.account AND o.action = r.action AND o.did = r.did
+				AND NOT (o.kind = r.kind AND o.name = r.name)),
+			IFNULL((SELECT a.action FROM actions a
+				WHERE a.account = r.account AND a.did = r.did AND a.action IN (r.action, 'un' || r.action)
+				ORDER BY a.id DESC LIMIT 1), '') = r.action
+		FROM reasons r
+		WHERE r.account = ? AND r.kind = ? AND r.name = ?
+		ORDER BY r.action, r.first_seen, r.rowid`, account, src.kind, src.name)
+	if err != nil {
+		return nil, fmt.Errorf("read users from %s: %w", src, err)
+	}
+	defer rows.Close()
+	var users []sourcedUser
+	for rows.Next() {
+		var u sourcedUser
+		err := rows.Scan(&u.action, &u.user.did, &u.user.handle, &u.others, &u.ours)
+		if err != nil {
+			return nil, fmt.Errorf("read users from %s: %w", src, err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// forgetSource forgets every reason from a source, such as after we stop trusting it.
+func (st *store) forgetSource(account string, src source) error {
+	_, err := st.db.Exec(`DELETE FROM reasons WHERE account = ? AND kind = ? AND name = ?`, account, src.kind, src.name)
+	if err != nil {
+		return fmt.Errorf("forget %s: %w", src, err)
+	}
+	return nil
+}
+
+// dropReason forgets that a source asks for a user to be muted or blocked.
+func (st *store) dropReason(account, action, did string, src source) error {
+	_, err := st.db.Exec(`DELETE FROM reasons WHERE account = ? AND action = ? AND did = ? AND kind = ? AND name = ?`,
+		account, action, did, src.kind, src.name)
+	if err != nil {
+		return fmt.Errorf("forget %s of %s from %s: %w", action, did, src, err)
+	}
+	return nil
+}
+
+// A reason is a source that asks for a user to be muted or blocked.
+type reason struct {
+	action    string // mute or block
+	src       source
+	firstSeen string
+	lastSeen  string
+}
+
+// reasonsFor returns the reasons our account has muted or blocked a user.
+func (st *store) reasonsFor(account, did string) ([]reason, error) {
+	rows, err := st.db.Query(`SELECT action, kind, name, handle, first_seen, last_seen FROM reasons
+		WHERE account = ? AND did = ? ORDER BY action, first_seen, rowid`, account, did)
+	if err != nil {
+		return nil, fmt.Errorf("read reasons for %s: %w", did, err)
+	}
+	defer rows.Close()
+	var reasons []reason
+	for rows.Next() {
+		var r reason
+		err := rows.Scan(&r.action, &r.src.kind, &r.src.name, &r.src.handle, &r.firstSeen, &r.lastSeen)
+		if err != nil {
+			return nil, fmt.Errorf("read reasons for %s: %w", did, err)
+		}
+		reasons = append(reasons, r)
+	}
+	return reasons, rows.Err()
+}
+
+// A historyEntry is a recorded action for a single user, along with the sources that asked for it.
+type historyEntry struct {
+	time    string
+	run     int64 // 0 if recorded before we tracked runs
+	command string
+	action  string // mute, block, unmute, or unblock
+	handle  string // the user's handle at the time, if known
+	sources []source
+}
+
+// history returns what our account has done to a user, oldest first.
+func (st *store) history(account, did string) ([]historyEntry, error) {
+	rows, err := st.db.Query(`SELECT a.id, a.time, IFNULL(a.run_id, 0), a.command, a.action, a.handle,
+			IFNULL(s.kind, ''), IFNULL(s.name, ''), IFNULL(s.handle, '')
+		FROM actions a LEFT JOIN action_sources s ON s.action_id = a.id
+		WHERE a.account = ? AND a.did = ?
+		ORDER BY a.id, s.rowid`, account, did)
+	if err != nil {
+		return nil, fmt.Errorf("read history for %s: %w", did, err)
+	}
+	defer rows.Close()
+	var entries []historyEntry
+	var lastID int64
+	for rows.Next() {
+		var id int64
+		var e historyEntry
+		var src source
+		err := rows.Scan(&id, &e.time, &e.run, &e.command, &e.action, &e.handle, &src.kind, &src.name, &src.handle)
+		if err != nil {
+			return nil, fmt.Errorf("read history for %s: %w", did, err)
+		}
+		if id != lastID {
+			entries = append(entries, e)
+			lastID = id
+		}
+		if src.kind != "" {
+			last := &entries[len(entries)-1]
+			last.sources = append(last.sources, src)
+		}
+	}
+	return entries, rows.Err()
+}
+
+// A run is one invocation of gomoderate that changed something.
+type run struct {
+	id      int64
+	time    string
+	command string
+	undoes  int64  // for an undo, the run it undid
+	undone  string // when this run was undone, if it was
+	counts  string // a summary of the actions, such as "mute 3, block 1"
+}
+
+// runs returns our account's most recent runs, newest first.
+func (st *store) runs(account string, limit int) ([]run, error) {
+	rows, err := st.db.Query(`
+		SELECT r.id, r.time, r.command, IFNULL(r.undoes, 0), IFNULL(r.undone, ''),
+			(SELECT IFNULL(GROUP_CONCAT(action || ' ' || n, ', '), '')
+				FROM (SELECT action, COUNT(*) AS n FROM actions WHERE run_id = r.id GROUP BY action))
+		FROM runs r
+		WHERE r.account = ?
+		ORDER BY r.id DESC
+		LIMIT ?`, account, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list runs: %w", err)
+	}
+	defer rows.Close()
+	var runs []run
+	for rows.Next() {
+		var r run
+		err := rows.Scan(&r.id, &r.time, &r.command, &r.undoes, &r.undone, &r.counts)
+		if err != nil {
+			return nil, fmt.Errorf("list runs: %w", err)
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// lastRun returns our account's most recent run that has not been undone
+// and is not itself an undo, or 0 if there is none.
+func (st *store) lastRun(account string) (int64, error) {
+	var id int64
+	err := st.db.QueryRow(`SELECT id FROM runs WHERE account = ? AND undone IS NULL AND undoes IS NULL ORDER BY id DESC LIMIT 1`,
+		account).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("find last run: %w", err)
+	}
+	return id, nil
+}
+
+// A recordedAction is an action as recorded in a run's journal.
+type recordedAction struct {
+	action string // mute, block, unmute, or unblock
+	user   resolvedUser
+}
+
+// runActions returns a run's journal of actions, in the order they happened.
+func (st *store) runActions(account string, id int64) (run, []recordedAction, error) {
+	r := run{id: id}
+	err := st.db.QueryRow(`SELECT time, command, IFNULL(undoes, 0), IFNULL(undone, '') FROM runs WHERE id = ? AND account = ?`,
+		id, account).Scan(&r.time, &r.command, &r.undoes, &r.undone)
+	if err == sql.ErrNoRows {
+		return run{}, nil, fmt.Errorf("no run %d found for this account", id)
+	}
+	if err != nil {
+		return run{}, nil, fmt.Errorf("read run %d: %w", id, err)
+	}
+
+	rows, err := st.db.Query(`SELECT action, did, handle FROM actions WHERE run_id = ? ORDER BY id`, id)
+	if err != nil {
+		return run{}, nil, fmt.Errorf("read run %d: %w", id, err)
+	}
+	defer rows.Close()
+	var actions []recordedAction
+	for rows.Next() {
+		var a recordedAction
+		err := rows.Scan(&a.action, &a.user.did, &a.user.handle)
+		if err != nil {
+			return run{}, nil, fmt.Errorf("read run %d: %w", id, err)
+		}
+		actions = append(actions, a)
+	}
+	return r, actions, rows.Err()
+}
+
+// markUndone records that a run has been undone.
+func (st *store) markUndone(id int64) error {
+	_, err := st.db.Exec(`UPDATE runs SET undone = ? WHERE id = ?`, time.Now().UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return fmt.Errorf("mark run %d undone: %w", id, err)
+	}
+	return nil
+}